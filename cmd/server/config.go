@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/pkg/orders"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// duration is a time.Duration read and written as a string such as "1m30s"
+type duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses a duration string
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText formats the duration as a string
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Config is the configuration of the order service. It is read from a YAML or TOML file,
+// then environment variables, then command-line flags, each overriding the previous one
+type Config struct {
+	Server     ServerConfig     `yaml:"server" toml:"server" json:"server"`
+	REST       RESTConfig       `yaml:"rest" toml:"rest" json:"rest"`
+	Log        LogConfig        `yaml:"log" toml:"log" json:"log"`
+	Auth       AuthConfig       `yaml:"auth" toml:"auth" json:"auth"`
+	TLS        TLSConfig        `yaml:"tls" toml:"tls" json:"tls"`
+	Orders     OrdersConfig     `yaml:"orders" toml:"orders" json:"orders"`
+	Dispatcher DispatcherConfig `yaml:"dispatcher" toml:"dispatcher" json:"dispatcher"`
+	Tracing    TracingConfig    `yaml:"tracing" toml:"tracing" json:"tracing"`
+	Features   FeaturesConfig   `yaml:"features" toml:"features" json:"features"`
+}
+
+// ServerConfig configures the listeners
+type ServerConfig struct {
+	// Port serving gRPC, gRPC-Web and REST together. Empty serves gRPC and REST on their own ports
+	Port               string   `yaml:"port" toml:"port" json:"port"`
+	GRPCPort           string   `yaml:"grpc_port" toml:"grpc_port" json:"grpc_port"`
+	RESTPort           string   `yaml:"rest_port" toml:"rest_port" json:"rest_port"`
+	AdminPort          string   `yaml:"admin_port" toml:"admin_port" json:"admin_port"` // Empty disables the admin listener
+	ShutdownDrainDelay duration `yaml:"shutdown_drain_delay" toml:"shutdown_drain_delay" json:"shutdown_drain_delay"`
+}
+
+// RESTConfig configures the decoding of the REST request bodies and the origins of the WebSocket feed
+type RESTConfig struct {
+	MaxBodySize          int      `yaml:"max_body_size" toml:"max_body_size" json:"max_body_size"` // In bytes
+	DiscardUnknownFields bool     `yaml:"discard_unknown_fields" toml:"discard_unknown_fields" json:"discard_unknown_fields"`
+	WebSocketOrigins     []string `yaml:"websocket_origins" toml:"websocket_origins" json:"websocket_origins"` // Host patterns, besides the service's own host
+}
+
+// LogConfig configures the structured logs
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level" json:"level"`
+	Format string `yaml:"format" toml:"format" json:"format"` // json or text
+}
+
+// AuthConfig locates the authentication and authorization files and sets the claims required of the tokens
+type AuthConfig struct {
+	JWKSFile    string `yaml:"jwks_file" toml:"jwks_file" json:"jwks_file"`
+	Audience    string `yaml:"audience" toml:"audience" json:"audience"`
+	Issuer      string `yaml:"issuer" toml:"issuer" json:"issuer"` // Required, no default
+	PolicyFile  string `yaml:"policy_file" toml:"policy_file" json:"policy_file"`
+	APIKeysFile string `yaml:"api_keys_file" toml:"api_keys_file" json:"api_keys_file"` // Optional, API keys are rejected if missing
+}
+
+// TLSConfig enables TLS when the certificate and key files are set, and mutual TLS
+// when the client CA file is set too
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" toml:"cert_file" json:"cert_file"`
+	KeyFile      string `yaml:"key_file" toml:"key_file" json:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file" json:"client_ca_file"`
+}
+
+// OrdersConfig configures the order service
+type OrdersConfig struct {
+	IdempotencyRetention      duration `yaml:"idempotency_retention" toml:"idempotency_retention" json:"idempotency_retention"`
+	IdempotencyPendingTimeout duration `yaml:"idempotency_pending_timeout" toml:"idempotency_pending_timeout" json:"idempotency_pending_timeout"`
+	OperationsRetention       duration `yaml:"operations_retention" toml:"operations_retention" json:"operations_retention"`
+	ValidationTimeout         duration `yaml:"validation_timeout" toml:"validation_timeout" json:"validation_timeout"` // 0 means no timeout
+	TaxRate                   float32  `yaml:"tax_rate" toml:"tax_rate" json:"tax_rate"`
+	ShippingFee               float32  `yaml:"shipping_fee" toml:"shipping_fee" json:"shipping_fee"`
+	FreeShippingFrom          float32  `yaml:"free_shipping_from" toml:"free_shipping_from" json:"free_shipping_from"`
+}
+
+// DispatcherConfig configures the order dispatcher
+type DispatcherConfig struct {
+	OrderLimit int `yaml:"order_limit" toml:"order_limit" json:"order_limit"`
+	BufferSize int `yaml:"buffer_size" toml:"buffer_size" json:"buffer_size"`
+}
+
+// TracingConfig chooses where spans are exported, if anywhere
+type TracingConfig struct {
+	OTLPEndpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint" json:"otlp_endpoint"`
+	File         string `yaml:"file" toml:"file" json:"file"`
+}
+
+// FeaturesConfig switches optional RPCs on and off
+type FeaturesConfig struct {
+	AsyncCreate bool `yaml:"async_create" toml:"async_create" json:"async_create"`
+	QuoteOrder  bool `yaml:"quote_order" toml:"quote_order" json:"quote_order"`
+}
+
+// defaultConfig returns the configuration used for the settings that are not set
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			GRPCPort:           "50051",
+			RESTPort:           "8080",
+			ShutdownDrainDelay: duration{5 * time.Second},
+		},
+		REST: RESTConfig{
+			MaxBodySize:          int(orders.DefaultBodyDecoding.MaxSize),
+			DiscardUnknownFields: orders.DefaultBodyDecoding.DiscardUnknown,
+		},
+		Log: LogConfig{Level: "info", Format: "json"},
+		Auth: AuthConfig{
+			JWKSFile:    "jwks.json",
+			Audience:    "orders-api",
+			PolicyFile:  "policy.yaml",
+			APIKeysFile: "apikeys.yaml",
+		},
+		Orders: OrdersConfig{
+			IdempotencyRetention:      duration{24 * time.Hour},
+			IdempotencyPendingTimeout: duration{time.Minute},
+			OperationsRetention:       duration{24 * time.Hour},
+			TaxRate:                   orders.DefaultPricing.TaxRate,
+			ShippingFee:               orders.DefaultPricing.ShippingFee,
+			FreeShippingFrom:          orders.DefaultPricing.FreeShippingFrom,
+		},
+		Dispatcher: DispatcherConfig{OrderLimit: 3, BufferSize: 100},
+		Features: FeaturesConfig{
+			AsyncCreate: orders.DefaultFeatures.AsyncCreate,
+			QuoteOrder:  orders.DefaultFeatures.QuoteOrder,
+		},
+	}
+}
+
+// setting is a configuration value that can be set by an environment variable and a flag
+type setting struct {
+	flag   string
+	env    string
+	usage  string
+	value  any  // Pointer to the Config field
+	reload bool // Whether a change is applied on SIGHUP, without a restart
+}
+
+// settings lists the settings of the configuration, bound to its fields
+func (c *Config) settings() []setting {
+	return []setting{
+		{"port", "PORT", "single port serving gRPC, gRPC-Web and REST, empty for the separate gRPC and REST ports", &c.Server.Port, false},
+		{"grpc-port", "GRPC_PORT", "port of the gRPC server", &c.Server.GRPCPort, false},
+		{"rest-port", "REST_PORT", "port of the REST server", &c.Server.RESTPort, false},
+		{"admin-port", "ADMIN_PORT", "port of the admin listener on localhost, empty to disable it", &c.Server.AdminPort, false},
+		{"shutdown-drain-delay", "SHUTDOWN_DRAIN_DELAY", "how long the service reports not serving before the servers stop", &c.Server.ShutdownDrainDelay, true},
+		{"rest-max-body-size", "REST_MAX_BODY_SIZE", "maximum size in bytes of a REST request body", &c.REST.MaxBodySize, true},
+		{"rest-discard-unknown-fields", "REST_DISCARD_UNKNOWN_FIELDS", "ignore unknown fields in REST request bodies instead of rejecting them", &c.REST.DiscardUnknownFields, true},
+		{"rest-websocket-origins", "REST_WEBSOCKET_ORIGINS", "comma-separated host patterns of the other origins whose pages may open the WebSocket feed", &c.REST.WebSocketOrigins, true},
+		{"log-level", "LOG_LEVEL", "log level: debug, info, warn or error", &c.Log.Level, true},
+		{"log-format", "LOG_FORMAT", "log format: json or text", &c.Log.Format, false},
+		{"jwks-file", "JWKS_FILE", "JWKS file with the public keys that sign the callers' tokens", &c.Auth.JWKSFile, false},
+		{"token-audience", "TOKEN_AUDIENCE", "audience the callers' tokens must be issued for", &c.Auth.Audience, false},
+		{"token-issuer", "TOKEN_ISSUER", "issuer of the callers' tokens, required", &c.Auth.Issuer, false},
+		{"policy-file", "POLICY_FILE", "file with the roles and their permissions", &c.Auth.PolicyFile, false},
+		{"api-keys-file", "API_KEYS_FILE", "file with the partner API keys, if it exists", &c.Auth.APIKeysFile, true},
+		{"tls-cert-file", "TLS_CERT_FILE", "TLS certificate file, enables TLS", &c.TLS.CertFile, false},
+		{"tls-key-file", "TLS_KEY_FILE", "TLS key file", &c.TLS.KeyFile, false},
+		{"tls-client-ca-file", "TLS_CLIENT_CA_FILE", "client CA file, enables mutual TLS", &c.TLS.ClientCAFile, false},
+		{"idempotency-retention", "IDEMPOTENCY_RETENTION", "how long responses to requests with an idempotency key are kept", &c.Orders.IdempotencyRetention, false},
+		{"idempotency-pending-timeout", "IDEMPOTENCY_PENDING_TIMEOUT", "how long a request with an idempotency key may run before its key can be used again", &c.Orders.IdempotencyPendingTimeout, false},
+		{"operations-retention", "OPERATIONS_RETENTION", "how long finished operations are kept", &c.Orders.OperationsRetention, false},
+		{"validation-timeout", "VALIDATION_TIMEOUT", "bound of the payment and inventory checks of an order, 0 for none", &c.Orders.ValidationTimeout, true},
+		{"tax-rate", "TAX_RATE", "share of the item price added as tax", &c.Orders.TaxRate, false},
+		{"shipping-fee", "SHIPPING_FEE", "flat shipping fee per order", &c.Orders.ShippingFee, false},
+		{"free-shipping-from", "FREE_SHIPPING_FROM", "subtotal from which shipping is free, 0 to disable", &c.Orders.FreeShippingFrom, false},
+		{"dispatcher-order-limit", "DISPATCHER_ORDER_LIMIT", "maximum number of orders fulfilled concurrently", &c.Dispatcher.OrderLimit, true},
+		{"dispatcher-buffer-size", "DISPATCHER_BUFFER_SIZE", "number of orders waiting for fulfillment before submissions block", &c.Dispatcher.BufferSize, false},
+		{"otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP gRPC endpoint the spans are exported to, http:// for no TLS", &c.Tracing.OTLPEndpoint, false},
+		{"traces-file", "TRACES_FILE", "file the spans are written to when there is no OTLP endpoint", &c.Tracing.File, false},
+		{"feature-async-create", "FEATURE_ASYNC_CREATE", "enable CreateAsync", &c.Features.AsyncCreate, true},
+		{"feature-quote-order", "FEATURE_QUOTE_ORDER", "enable QuoteOrder", &c.Features.QuoteOrder, true},
+	}
+}
+
+// set parses s into the Config field the setting is bound to
+func (s setting) set(v string) error {
+	var err error
+	switch p := s.value.(type) {
+	case *string:
+		*p = v
+	case *int:
+		*p, err = strconv.Atoi(v)
+	case *bool:
+		*p, err = strconv.ParseBool(v)
+	case *float32:
+		var f float64
+		f, err = strconv.ParseFloat(v, 32)
+		*p = float32(f)
+	case *duration:
+		err = p.UnmarshalText([]byte(v))
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported setting type %T", p)
+	}
+	return err
+}
+
+// loadConfig reads the configuration from the defaults, the file given by --config (or CONFIG_FILE),
+// the environment and the flags, in that order. printConfig tells whether --print-config was given
+func loadConfig(args []string) (cfg Config, printConfig bool, err error) {
+	cfg = defaultConfig()
+	settings := cfg.settings()
+
+	// Flags are parsed first to find the configuration file, and applied last
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file (env CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		s := s
+		fs.Func(s.flag, s.usage+" (env "+s.env+")", func(v string) error {
+			// Check the value now, so that errors are reported against the flag
+			if err := s.set(v); err != nil {
+				return err
+			}
+			flagValues[s.flag] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+	cfg = defaultConfig()
+	settings = cfg.settings()
+
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			return Config{}, false, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(v); err != nil {
+				return Config{}, false, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := s.set(v); err != nil {
+				return Config{}, false, fmt.Errorf("--%s: %w", s.flag, err)
+			}
+		}
+	}
+	return cfg, printConfig, cfg.validate()
+}
+
+// readFile reads the configuration file over the current configuration. The format
+// is told by the file extension. Unknown settings are rejected, as they are likely typos
+func (c *Config) readFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		if errors.Is(err, io.EOF) {
+			err = nil // An empty file changes nothing
+		}
+	case ".toml":
+		err = toml.NewDecoder(f).DisallowUnknownFields().Decode(c)
+	default:
+		err = fmt.Errorf("unsupported configuration format %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// validate reports every invalid setting of the configuration
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	validPort := func(port string) bool {
+		n, err := strconv.Atoi(port)
+		return err == nil && n > 0 && n < 65536
+	}
+	check(validPort(c.Server.GRPCPort), "server.grpc_port: invalid port %q", c.Server.GRPCPort)
+	check(validPort(c.Server.RESTPort), "server.rest_port: invalid port %q", c.Server.RESTPort)
+	check(c.Server.AdminPort == "" || validPort(c.Server.AdminPort), "server.admin_port: invalid port %q", c.Server.AdminPort)
+	ports := []string{c.Server.GRPCPort, c.Server.RESTPort, c.Server.AdminPort}
+	check(ports[0] != ports[1] && ports[0] != ports[2] && ports[1] != ports[2], "server: the gRPC, REST and admin ports must differ")
+	check(c.Server.Port == "" || validPort(c.Server.Port), "server.port: invalid port %q", c.Server.Port)
+	check(c.Server.Port == "" || c.Server.Port != c.Server.AdminPort, "server: the port and the admin port must differ")
+	check(c.Server.ShutdownDrainDelay.Duration >= 0, "server.shutdown_drain_delay: must not be negative")
+
+	check(c.REST.MaxBodySize > 0, "rest.max_body_size: must be positive")
+	for _, pattern := range c.REST.WebSocketOrigins {
+		_, err := filepath.Match(pattern, "")
+		check(err == nil, "rest.websocket_origins: invalid pattern %q", pattern)
+	}
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level: invalid level %q", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format: must be json or text, not %q", c.Log.Format)
+
+	check(c.Auth.JWKSFile != "", "auth.jwks_file: required")
+	check(c.Auth.Audience != "", "auth.audience: required")
+	check(c.Auth.Issuer != "", "auth.issuer: required")
+	check(c.Auth.PolicyFile != "", "auth.policy_file: required")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls: cert_file and key_file must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls.client_ca_file: requires cert_file and key_file")
+
+	check(c.Orders.IdempotencyRetention.Duration > 0, "orders.idempotency_retention: must be positive")
+	check(c.Orders.IdempotencyPendingTimeout.Duration > 0, "orders.idempotency_pending_timeout: must be positive")
+	check(c.Orders.OperationsRetention.Duration > 0, "orders.operations_retention: must be positive")
+	check(c.Orders.ValidationTimeout.Duration >= 0, "orders.validation_timeout: must not be negative")
+	check(c.Orders.TaxRate >= 0 && c.Orders.TaxRate <= 1, "orders.tax_rate: must be between 0 and 1")
+	check(c.Orders.ShippingFee >= 0, "orders.shipping_fee: must not be negative")
+	check(c.Orders.FreeShippingFrom >= 0, "orders.free_shipping_from: must not be negative")
+
+	check(c.Dispatcher.OrderLimit > 0, "dispatcher.order_limit: must be positive")
+	check(c.Dispatcher.BufferSize >= 0, "dispatcher.buffer_size: must not be negative")
+
+	check(c.Tracing.OTLPEndpoint == "" || c.Tracing.File == "", "tracing: set otlp_endpoint or file, not both")
+
+	return errors.Join(errs...)
+}
+
+// features returns the order service features of the configuration
+func (c *Config) features() orders.Features {
+	return orders.Features{
+		AsyncCreate: c.Features.AsyncCreate,
+		QuoteOrder:  c.Features.QuoteOrder,
+	}
+}
+
+// bodyDecoding returns the decoding of the REST request bodies of the configuration
+func (c *Config) bodyDecoding() orders.BodyDecoding {
+	return orders.BodyDecoding{
+		MaxSize:        int64(c.REST.MaxBodySize),
+		DiscardUnknown: c.REST.DiscardUnknownFields,
+	}
+}
+
+// restartRequired returns the flag names of the settings that differ between the configurations
+// and cannot be applied without a restart
+func restartRequired(current, next Config) []string {
+	var changed []string
+	nextSettings := next.settings()
+	for i, s := range current.settings() {
+		if !s.reload && fmt.Sprint(reflect.ValueOf(s.value).Elem()) != fmt.Sprint(reflect.ValueOf(nextSettings[i].value).Elem()) {
+			changed = append(changed, s.flag)
+		}
+	}
+	return changed
+}
+
+// pricing returns the order pricing of the configuration
+func (c *Config) pricing() orders.Pricing {
+	return orders.Pricing{
+		TaxRate:          c.Orders.TaxRate,
+		ShippingFee:      c.Orders.ShippingFee,
+		FreeShippingFrom: c.Orders.FreeShippingFrom,
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	writeFile := func(t *testing.T, name, data string) string {
+		file := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	const issuer = "--token-issuer=https://auth.example.com/"
+
+	tests := []struct {
+		name    string
+		file    string // YAML configuration file, if any
+		toml    string // TOML configuration file, if any
+		env     map[string]string
+		args    []string
+		check   func(cfg Config) bool
+		wantErr string // Part of the error message
+	}{
+		{
+			name:  "defaults",
+			args:  []string{issuer},
+			check: func(cfg Config) bool { return cfg.Server.RESTPort == "8080" && cfg.Auth.Audience == "orders-api" },
+		},
+		{
+			name: "file over the defaults",
+			file: "server:\n  rest_port: \"9000\"\norders:\n  validation_timeout: 3s\n",
+			args: []string{issuer},
+			check: func(cfg Config) bool {
+				return cfg.Server.RESTPort == "9000" && cfg.Orders.ValidationTimeout.Duration == 3*time.Second
+			},
+		},
+		{
+			name:  "TOML file",
+			toml:  "[server]\nrest_port = \"9000\"\n",
+			args:  []string{issuer},
+			check: func(cfg Config) bool { return cfg.Server.RESTPort == "9000" },
+		},
+		{
+			name:  "environment over the file",
+			file:  "server:\n  rest_port: \"9000\"\n  grpc_port: \"9001\"\n",
+			env:   map[string]string{"REST_PORT": "9100"},
+			args:  []string{issuer},
+			check: func(cfg Config) bool { return cfg.Server.RESTPort == "9100" && cfg.Server.GRPCPort == "9001" },
+		},
+		{
+			name: "flags over the environment",
+			file: "server:\n  rest_port: \"9000\"\n",
+			env:  map[string]string{"REST_PORT": "9100", "TOKEN_ISSUER": "https://env.example.com/"},
+			args: []string{"--rest-port=9200"},
+			check: func(cfg Config) bool {
+				return cfg.Server.RESTPort == "9200" && cfg.Auth.Issuer == "https://env.example.com/"
+			},
+		},
+		{
+			name: "lists",
+			env:  map[string]string{"REST_WEBSOCKET_ORIGINS": "shop.example.com, *.partner.test,"},
+			args: []string{issuer},
+			check: func(cfg Config) bool {
+				return reflect.DeepEqual(cfg.REST.WebSocketOrigins, []string{"shop.example.com", "*.partner.test"})
+			},
+		},
+		{name: "unknown file setting", file: "server:\n  rest_prot: \"9000\"\n", args: []string{issuer}, wantErr: "rest_prot"},
+		{name: "invalid environment value", env: map[string]string{"REST_MAX_BODY_SIZE": "big"}, args: []string{issuer}, wantErr: "REST_MAX_BODY_SIZE"},
+		{name: "invalid flag value", args: []string{issuer, "--validation-timeout=soon"}, wantErr: "validation-timeout"},
+		{name: "invalid configuration", args: []string{issuer, "--rest-port=50051"}, wantErr: "ports must differ"},
+		{name: "issuer required", wantErr: "auth.issuer: required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			switch {
+			case tt.file != "":
+				args = append(args, "--config", writeFile(t, "config.yaml", tt.file))
+			case tt.toml != "":
+				// The file can be given by the environment too
+				t.Setenv("CONFIG_FILE", writeFile(t, "config.toml", tt.toml))
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg, _, err := loadConfig(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want an error about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("loadConfig() = %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	current := defaultConfig()
+	next := defaultConfig()
+	next.Log.Level = "debug"
+	next.Orders.ValidationTimeout.Duration = time.Second
+	next.REST.WebSocketOrigins = []string{"shop.example.com"}
+	if changed := restartRequired(current, next); len(changed) != 0 {
+		t.Errorf("restartRequired() with reloadable settings changed = %v, want none", changed)
+	}
+
+	next.Server.GRPCPort = "9001"
+	next.Orders.TaxRate = 0.1
+	if changed := restartRequired(current, next); !reflect.DeepEqual(changed, []string{"grpc-port", "tax-rate"}) {
+		t.Errorf("restartRequired() = %v, want [grpc-port tax-rate]", changed)
+	}
+}
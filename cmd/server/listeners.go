@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// listenFDsStart is the first inherited file descriptor, after stdin, stdout and stderr
+	listenFDsStart = 3
+	// inheritedListenersEnv names the listeners handed over by the parent process, in file descriptor order
+	inheritedListenersEnv = "ORDERS_LISTEN_FDNAMES"
+	// readyFDEnv is the file descriptor the new process writes to once it serves
+	readyFDEnv = "ORDERS_READY_FD"
+	// upgradeReadyTimeout is how long the old process waits for the new one during an upgrade
+	upgradeReadyTimeout = 30 * time.Second
+)
+
+// Listener names, which are also the FileDescriptorName= of the systemd socket units
+const (
+	grpcListener   = "grpc"
+	restListener   = "rest"
+	singleListener = "http" // gRPC, gRPC-Web and REST on a single port
+	adminListener  = "admin"
+)
+
+// listeners hands out the listeners of the servers by name. They are inherited from the parent process
+// during an upgrade, or from systemd with socket activation, and opened otherwise
+type listeners struct {
+	inherited map[string]net.Listener
+	unnamed   []net.Listener // Inherited listeners without names, handed out in order
+	names     []string       // Names of the listeners in use, in the order they were handed out
+	active    map[string]net.Listener
+}
+
+// inheritListeners takes over the listeners passed by the parent process or by systemd, if any
+func inheritListeners() (*listeners, error) {
+	l := &listeners{inherited: make(map[string]net.Listener), active: make(map[string]net.Listener)}
+
+	names, named, err := inheritedNames()
+	if err != nil {
+		return nil, err
+	}
+	// Children of this process must not take the variables for their own
+	for _, env := range []string{inheritedListenersEnv, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env)
+	}
+
+	for i, name := range names {
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %q: %w", name, err)
+		}
+		if named {
+			l.inherited[name] = ln
+		} else {
+			l.unnamed = append(l.unnamed, ln)
+		}
+	}
+	return l, nil
+}
+
+// inheritedNames returns the names of the inherited listeners, in file descriptor order, and whether
+// they are named. Without names, the listeners are taken in the order the servers listen. systemd names
+// the sockets after their unit without FileDescriptorName=, so names that are all unknown count as none
+func inheritedNames() (names []string, named bool, err error) {
+	if env := os.Getenv(inheritedListenersEnv); env != "" {
+		return strings.Split(env, ":"), true, nil
+	}
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, false, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, false, fmt.Errorf("LISTEN_FDS: %w", err)
+	}
+	names = strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	if len(names) != n || !slices.ContainsFunc(names, isListenerName) {
+		return make([]string, n), false, nil
+	}
+	return names, true, nil
+}
+
+// isListenerName tells whether name is the name of the listener of a server
+func isListenerName(name string) bool {
+	switch name {
+	case grpcListener, restListener, singleListener, adminListener:
+		return true
+	}
+	return false
+}
+
+// listen returns the inherited listener with the given name, or a new TCP listener on addr
+func (l *listeners) listen(name, addr string) (net.Listener, error) {
+	ln, ok := l.inherited[name]
+	if ok {
+		delete(l.inherited, name)
+	} else if len(l.unnamed) > 0 {
+		ln, l.unnamed, ok = l.unnamed[0], l.unnamed[1:], true
+	}
+	if ok {
+		slog.Info("using inherited listener", "listener", name, "address", ln.Addr().String())
+	} else {
+		var err error
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	l.names = append(l.names, name)
+	l.active[name] = ln
+	return ln, nil
+}
+
+// closeUnused closes the inherited listeners no server took over
+func (l *listeners) closeUnused() {
+	for name, ln := range l.inherited {
+		slog.Warn("closing unused inherited listener", "listener", name, "address", ln.Addr().String())
+		ln.Close()
+		delete(l.inherited, name)
+	}
+	for _, ln := range l.unnamed {
+		slog.Warn("closing unused inherited listener", "address", ln.Addr().String())
+		ln.Close()
+	}
+	l.unnamed = nil
+}
+
+// upgrade starts a new process of the executable with the same arguments, hands it the listeners
+// and waits until it serves. The caller then drains and exits, while the new process keeps accepting
+// connections on the same sockets. Orders are kept in memory, so the new process starts without them
+func (l *listeners) upgrade() error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range l.names {
+		ln, ok := l.active[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %q cannot be handed over", name)
+		}
+		f, err := ln.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		inheritedListenersEnv+"="+strings.Join(l.names, ":"),
+		readyFDEnv+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+	err = cmd.Start()
+	readyW.Close() // The new process holds the only write end, so its exit ends the read below
+	if err != nil {
+		return err
+	}
+
+	_ = ready.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+	if _, err := ready.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("new process not ready after %s", upgradeReadyTimeout)
+		}
+		return errors.New("new process exited before it was ready")
+	}
+	slog.Info("new process serves on the listeners", "pid", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// notifyReady tells the parent process, during an upgrade, that this process serves on the listeners
+func notifyReady() {
+	fd, err := strconv.Atoi(os.Getenv(readyFDEnv))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(readyFDEnv)
+
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		slog.Error("failed to notify the parent process", "error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestInheritedNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		fds     string
+		fdNames string
+		want    []string
+		named   bool
+		wantErr bool
+	}{
+		{name: "named", fds: "2", fdNames: "grpc:rest", want: []string{"grpc", "rest"}, named: true},
+		{name: "partly named", fds: "2", fdNames: "http:orders.socket", want: []string{"http", "orders.socket"}, named: true},
+		{name: "socket unit names", fds: "2", fdNames: "orders.socket:orders.socket", want: []string{"", ""}},
+		{name: "no names", fds: "2", fdNames: "", want: []string{"", ""}},
+		{name: "fewer names", fds: "3", fdNames: "grpc:rest", want: []string{"", "", ""}},
+		{name: "invalid count", fds: "two", fdNames: "grpc:rest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(inheritedListenersEnv, "")
+			t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+			t.Setenv("LISTEN_FDS", tt.fds)
+			t.Setenv("LISTEN_FDNAMES", tt.fdNames)
+
+			names, named, err := inheritedNames()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("inheritedNames() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(names, tt.want) || named != tt.named {
+				t.Errorf("inheritedNames() = %q, %v, want %q, %v", names, named, tt.want, tt.named)
+			}
+		})
+	}
+}
+
+func TestInheritedNamesOtherProcess(t *testing.T) {
+	t.Setenv(inheritedListenersEnv, "")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "grpc")
+	if names, _, err := inheritedNames(); names != nil || err != nil {
+		t.Errorf("inheritedNames() = %q, %v, want no listeners for another process", names, err)
+	}
+}
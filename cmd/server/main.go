@@ -1,21 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/AndreiMartynenko/grpc-eshop/pkg/orders"
-	"github.com/AndreiMartynenko/grpc-eshop/proto"
-)
-
-const (
-	grpcPort = "50051"
-	restPort = "8080"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
 )
 
 // The app wrapper is perfect for all elements needed to start
@@ -23,148 +25,304 @@ const (
 type app struct {
 	restServer orders.RestServer
 	grpcServer orders.GrpcServer
+	dispatcher orders.OrderDispatcher
+	operations *orders.Operations
+	health     *orders.Health
+	admin      *orders.AdminServer // nil unless the admin listener is enabled
+	mux        *orders.MuxServer   // Serves gRPC and REST on a single port, nil for separate ports
+	// The settings that can be reloaded are applied to these
+	orderService *orders.OrderService
+	apiKeys      *orders.APIKeyStore     // nil when the API keys file did not exist at startup
+	config       *atomic.Pointer[Config] // The configuration in effect
 	//Listens for an application termination signal
 	//Ex. (Ctrl X, Docker container shutdown, etc)
 	shutdownCh chan os.Signal
+	// Listens for SIGHUP, asking to reload the configuration
+	reloadCh chan os.Signal
+	// Listens for SIGUSR2, asking to hand the listeners to a new process
+	upgradeCh chan os.Signal
+	listeners *listeners
 }
 
-// start launches the REST and gRPC servers in the background
+// start launches the REST and gRPC servers, the order dispatcher and the health checks in the background
 func (a app) start() {
-	go a.restServer.Start() // non-blocking now
-	go a.grpcServer.Start() // also non-blocking :-)
+	a.dispatcher.Start()
+	a.health.Start()
+	if a.mux != nil {
+		a.mux.Start()
+	} else {
+		go a.restServer.Start() // non-blocking now
+		go a.grpcServer.Start() // also non-blocking :-)
+	}
+	if a.admin != nil {
+		a.admin.Start()
+	}
 }
 
-// stop stops the servers
+// stop reports the service as not serving and leaves load balancers time to drain traffic,
+// stops the servers, cancels the running operations, then waits for the dispatcher to process the submitted orders
 func (a app) shutdown() error {
-	a.grpcServer.Stop()
-	return a.restServer.Stop()
-}
+	a.health.Shutdown()
+	time.Sleep(a.config.Load().Server.ShutdownDrainDelay.Duration)
 
-// newApp creates a new application with REST and gRPC servers
-// This function performs all necessary application initialization
-func newApp() (app, error) {
-	orderService := proto.UnimplementedOrderServiceServer{}
+	if a.admin != nil {
+		a.admin.Stop()
+	}
+	var err error
+	if a.mux != nil {
+		err = a.mux.Stop()
+	} else {
+		a.grpcServer.Stop()
+		err = a.restServer.Stop()
+	}
+	// Operations still running would submit orders to the stopped dispatcher
+	a.operations.Shutdown()
+	a.dispatcher.Shutdown()
+	return err
+}
 
-	gs, err := orders.NewGrpcServer(orderService, grpcPort)
-	if err != nil {
-		return app{}, err
+// muxErrors returns the error channel of the single port server, or nil (never ready) with separate ports
+func (a app) muxErrors() chan error {
+	if a.mux == nil {
+		return nil
 	}
+	return a.mux.Error()
+}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+// adminErrors returns the error channel of the admin server, or nil (never ready) if it is disabled
+func (a app) adminErrors() chan error {
+	if a.admin == nil {
+		return nil
+	}
+	return a.admin.Error()
+}
 
-	return app{
-		restServer: orders.NewRestServer(orderService, restPort),
-		grpcServer: gs,
-		shutdownCh: quit,
-	}, nil
+// runtimeConfig returns the configuration of the running service, as dumped by the admin server
+func (a app) runtimeConfig() any {
+	cfg := *a.config.Load()
+	cfg.Log.Level = strings.ToLower(orders.LogLevel.Level().String())
+	return cfg
 }
 
-// run starts the application, handling any errors from REST and gRPC servers
-// and shutdown signals
-func run() error {
-	app, err := newApp()
+// reload reads the configuration again and applies the settings that can change while the service
+// runs: the log level, the API keys and their rate limits, the timeouts, the dispatcher concurrency,
+// the features and the decoding of REST request bodies. Nothing is applied if the configuration
+// is invalid or a setting that needs a restart changed
+func (a app) reload() {
+	current := a.config.Load()
+	next, _, err := loadConfig(os.Args[1:])
 	if err != nil {
-		return err
+		slog.Error("configuration not reloaded: invalid configuration", "error", err)
+		return
+	}
+	if changed := restartRequired(*current, next); len(changed) > 0 {
+		slog.Error("configuration not reloaded: these settings need a restart", "settings", changed)
+		return
 	}
 
-	app.start()
-	defer app.shutdown()
-
-	select {
-	case restErr := <-app.restServer.Error():
-		return restErr
-	case grpcErr := <-app.grpcServer.Error():
-		return grpcErr
-	case <-app.shutdownCh:
-		return nil
+	if a.apiKeys != nil {
+		if err := a.apiKeys.Reload(next.Auth.APIKeysFile); err != nil {
+			slog.Error("configuration not reloaded: invalid API keys", "error", err)
+			return
+		}
+	} else if _, err := os.Stat(next.Auth.APIKeysFile); err == nil {
+		slog.Warn("API keys file found, API keys are accepted after a restart", "file", next.Auth.APIKeysFile)
 	}
-}
+	_ = orders.LogLevel.UnmarshalText([]byte(next.Log.Level))
+	a.orderService.SetValidationTimeout(next.Orders.ValidationTimeout.Duration)
+	a.orderService.SetFeatures(next.features())
+	a.dispatcher.SetOrderLimit(next.Dispatcher.OrderLimit)
+	a.restServer.SetBodyDecoding(next.bodyDecoding())
+	a.restServer.SetWebSocketOrigins(next.REST.WebSocketOrigins)
+	a.config.Store(&next)
 
-// OrderDispatcher is a daemon process that creates a set of handlers using sync.WaitGroup to concurrently
-// process and dispatch orders
-type OrderDispatcher struct {
-	ordersCh   chan *proto.Order
-	orderLimit int // maximum number of orders the pool will process concurrently
+	slog.Info("configuration reloaded",
+		"log_level", next.Log.Level,
+		"validation_timeout", next.Orders.ValidationTimeout.String(),
+		"shutdown_drain_delay", next.Server.ShutdownDrainDelay.String(),
+		"dispatcher_order_limit", next.Dispatcher.OrderLimit,
+		"features", next.Features,
+		"rest", next.REST,
+	)
 }
 
-// NewOrderDispatcher creates a new OrderDispatcher
-func NewOrderDispatcher(orderLimit int, bufferSize int) OrderDispatcher {
-	return OrderDispatcher{
-		ordersCh:   make(chan *proto.Order, bufferSize),
-		orderLimit: orderLimit,
+// newApp creates a new application with REST and gRPC servers
+// This function performs all necessary application initialization
+func newApp(cfg Config) (app, error) {
+	dispatcher := orders.NewOrderDispatcher(cfg.Dispatcher.OrderLimit, cfg.Dispatcher.BufferSize)
+	store := orders.NewOrderStore()
+	health := orders.NewHealth(store, dispatcher)
+	operations := orders.NewOperations(cfg.Orders.OperationsRetention.Duration)
+	service := orders.NewOrderService(store, operations, dispatcher, cfg.pricing(), cfg.Orders.ValidationTimeout.Duration, cfg.features())
+	orderService := orders.NewIdempotentService(service, orders.NewIdempotencyStore(cfg.Orders.IdempotencyRetention.Duration, cfg.Orders.IdempotencyPendingTimeout.Duration))
+
+	var apiKeys *orders.APIKeyStore
+	if _, err := os.Stat(cfg.Auth.APIKeysFile); err == nil {
+		if apiKeys, err = orders.LoadAPIKeys(cfg.Auth.APIKeysFile); err != nil {
+			return app{}, err
+		}
+	}
+	auth, err := orders.NewAuthenticator(cfg.Auth.JWKSFile, cfg.Auth.Audience, cfg.Auth.Issuer, apiKeys)
+	if err != nil {
+		return app{}, err
+	}
+	policy, err := orders.LoadPolicy(cfg.Auth.PolicyFile)
+	if err != nil {
+		return app{}, err
 	}
-}
 
-// SubmitOrder submits an order for processing
-func (d OrderDispatcher) SubmitOrder(order *proto.Order) {
-	go func() {
-		d.ordersCh <- order
-	}()
-}
+	// The client CA file additionally requires client certificates (mutual TLS)
+	var grpcTLS, restTLS *tls.Config
+	if cfg.TLS.CertFile != "" {
+		certs, err := orders.NewTLSReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			return app{}, err
+		}
+		grpcTLS = certs.Config("h2")
+		restTLS = certs.Config("h2", "http/1.1")
+	}
 
-// Start launches the dispatcher in the background
-func (d OrderDispatcher) Start() {
-	go d.processOrders()
-}
+	// The listeners are inherited during an upgrade or with systemd socket activation
+	lns, err := inheritListeners()
+	if err != nil {
+		return app{}, err
+	}
+	// With a single port, the gRPC and REST servers get no listener and TLS is terminated by the mux server
+	var grpcLis, restLis, muxLis net.Listener
+	muxTLS := restTLS
+	if cfg.Server.Port != "" {
+		if muxLis, err = lns.listen(singleListener, ":"+cfg.Server.Port); err != nil {
+			return app{}, err
+		}
+		grpcTLS, restTLS = nil, nil
+	} else {
+		if grpcLis, err = lns.listen(grpcListener, ":"+cfg.Server.GRPCPort); err != nil {
+			return app{}, err
+		}
+		if restLis, err = lns.listen(restListener, ":"+cfg.Server.RESTPort); err != nil {
+			return app{}, err
+		}
+	}
+	gs := orders.NewGrpcServer(orderService, operations, health, auth, policy, grpcTLS, grpcLis)
 
-// Shutdown shuts down the OrderDispatcher by closing the orders channel
-// Note: This function should only be executed after the last order
-// has entered the orders channel. Sending an order to a closed channel will panic.
-func (d OrderDispatcher) Shutdown() {
-	close(d.ordersCh)
-}
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGUSR2)
+
+	a := app{
+		restServer:   orders.NewRestServer(orderService, operations, store.Changes(), health, auth, policy, cfg.bodyDecoding(), restTLS, restLis),
+		grpcServer:   gs,
+		dispatcher:   dispatcher,
+		operations:   operations,
+		health:       health,
+		orderService: service,
+		apiKeys:      apiKeys,
+		config:       &atomic.Pointer[Config]{},
+		shutdownCh:   quit,
+		reloadCh:     reload,
+		upgradeCh:    upgrade,
+		listeners:    lns,
+	}
+	a.config.Store(&cfg)
+	a.restServer.SetWebSocketOrigins(cfg.REST.WebSocketOrigins)
+	if muxLis != nil {
+		mux := orders.NewMuxServer(gs, a.restServer, muxTLS, muxLis)
+		a.mux = &mux
+	}
 
-// processOrders processes all incoming orders in the background using
-// for-range and sync.WaitGroup
-func (d OrderDispatcher) processOrders() {
-	limiter := make(chan struct{}, d.orderLimit)
-	var wg sync.WaitGroup
-
-	// Continuous processing of orders received from the orders channel
-	// This loop will exit after the channel is closed
-	for order := range d.ordersCh {
-		limiter <- struct{}{}
-		wg.Add(1)
-
-		go func(order *proto.Order) {
-			// What needs to be done: start the fulfillment process to pack and ship the order
-			// Currently using a sleep and print for demonstration
-			time.Sleep(50 * time.Millisecond)
-			fmt.Printf("Order (%v) has shipped \n", order)
-			<-limiter
-			wg.Done()
-		}(order)
-	}
-	wg.Wait()
+	// The admin listener serves reflection, channelz, pprof and the configuration
+	if cfg.Server.AdminPort != "" {
+		adminLis, err := lns.listen(adminListener, "localhost:"+cfg.Server.AdminPort)
+		if err != nil {
+			return app{}, err
+		}
+		as := orders.NewAdminServer(gs, a.runtimeConfig, adminLis)
+		a.admin = &as
+	}
+	lns.closeUnused()
+	return a, nil
 }
 
-func main() {
-	if err := run(); err != nil {
-		log.Fatal(err)
+// run starts the application, handling any errors from REST and gRPC servers,
+// shutdown signals, configuration reloads and upgrades
+func run(cfg Config) error {
+	shutdownTracing, err := orders.SetupTracing(context.Background(), cfg.Tracing.OTLPEndpoint, cfg.Tracing.File)
+	if err != nil {
+		return err
 	}
+	defer shutdownTracing(context.Background())
 
-	dispatcher := NewOrderDispatcher(3, 100)
-	dispatcher.Start()
-	defer dispatcher.Shutdown()
+	app, err := newApp(cfg)
+	if err != nil {
+		return err
+	}
 
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "iPhone Screen Protector", Price: 9.99}}})
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "iPhone Case", Price: 19.99}}})
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "Pixel Case", Price: 14.99}}})
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "Bluetooth Speaker", Price: 29.99}}})
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "4K Monitor", Price: 159.99}}})
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "Inkjet Printer", Price: 79.99}}})
+	app.start()
+	defer app.shutdown()
+	notifyReady()
 
-	time.Sleep(5 * time.Second) // just for testing
+	for {
+		select {
+		case restErr := <-app.restServer.Error():
+			return restErr
+		case grpcErr := <-app.grpcServer.Error():
+			return grpcErr
+		case muxErr := <-app.muxErrors():
+			return muxErr
+		case adminErr := <-app.adminErrors():
+			return adminErr
+		case <-app.shutdownCh:
+			return nil
+		case <-app.reloadCh:
+			app.reload()
+		case <-app.upgradeCh:
+			// The new process serves on the listeners, this one drains its connections and exits
+			if err := app.listeners.upgrade(); err != nil {
+				slog.Error("upgrade failed, still serving", "error", err)
+				continue
+			}
+			return nil
+		}
+	}
+}
 
-	// Continue submitting orders or perform any other operations
-	// before the main function exits.
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "Mouse", Price: 14.99}}})
-	dispatcher.SubmitOrder(&proto.Order{Items: []*proto.Item{{Description: "Keyboard", Price: 29.99}}})
+func main() {
+	cfg, printConfig, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Let the program run for a while to process the additional orders
-	time.Sleep(5 * time.Second)
+	// The log level can be changed at runtime
+	_ = orders.LogLevel.UnmarshalText([]byte(cfg.Log.Level))
+	opts := &slog.HandlerOptions{Level: orders.LogLevel}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if cfg.Log.Format == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(orders.NewLogHandler(handler)))
+	// Gin lists the routes on stdout in debug mode, which only matters when debugging
+	if orders.LogLevel.Level() > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
 
-	// After processing, you can shut down the dispatcher
-	dispatcher.Shutdown()
+	if err := run(cfg); err != nil {
+		slog.Error("order service stopped", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,87 @@
+package orders
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"google.golang.org/grpc"
+	channelzservice "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// AdminServer implements the admin and debug listener. It serves, without authentication:
+//   - gRPC server reflection of the order service and channelz, over cleartext HTTP/2
+//   - pprof under /debug/pprof/
+//   - the runtime configuration under /config
+//
+// Its listener should be bound to localhost, so it is reachable from the host (or the pod) and not from the network
+type AdminServer struct {
+	server   *http.Server
+	listener net.Listener
+	errCh    chan error
+}
+
+// NewAdminServer creates an AdminServer serving on lis, reflecting the services of the gRPC server
+// and dumping the configuration returned by config as JSON
+func NewAdminServer(services GrpcServer, config func() any, lis net.Listener) AdminServer {
+	grpcServer := grpc.NewServer()
+	reflectionOpts := reflection.ServerOptions{Services: services.server}
+	reflectionv1.RegisterServerReflectionServer(grpcServer, reflection.NewServerV1(reflectionOpts))
+	reflectionv1alpha.RegisterServerReflectionServer(grpcServer, reflection.NewServer(reflectionOpts))
+	channelzservice.RegisterChannelzServiceToServer(grpcServer)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(config())
+	})
+
+	// gRPC calls share the listener with the debug endpoints, told apart by their content type
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	return AdminServer{
+		server: &http.Server{
+			Handler: h2c.NewHandler(handler, &http2.Server{}),
+		},
+		listener: lis,
+		errCh:    make(chan error),
+	}
+}
+
+// Start launches the admin server in the background, sending errors to the error channel
+func (a AdminServer) Start() {
+	go func() {
+		a.errCh <- a.server.Serve(a.listener)
+	}()
+}
+
+// Stop stops the server
+func (a AdminServer) Stop() error {
+	return a.server.Close()
+}
+
+// Error returns the server's error channel
+func (a AdminServer) Error() chan error {
+	return a.errCh
+}
@@ -0,0 +1,200 @@
+package orders
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// APIKeyHeader is the HTTP header carrying an API key
+	APIKeyHeader = "X-API-Key"
+	// APIKeyMetadata is the gRPC metadata key carrying an API key
+	APIKeyMetadata = "x-api-key"
+)
+
+var (
+	ErrInvalidAPIKey = status.Error(codes.Unauthenticated, "invalid API key")
+	ErrAPIKeyExpired = status.Error(codes.Unauthenticated, "API key expired")
+)
+
+// APIKey describes a partner API key. Only the SHA-256 hash of the key is stored
+type APIKey struct {
+	ID         string    `yaml:"id"`
+	Hash       string    `yaml:"hash"`        // Hex SHA-256 of the key
+	Scopes     []string  `yaml:"scopes"`      // Policy roles granted to the key
+	Expires    time.Time `yaml:"expires"`     // Zero for keys that do not expire
+	Rate       float64   `yaml:"rate"`        // Requests per second, 0 for no limit
+	Burst      int       `yaml:"burst"`       // Requests allowed at once above the rate
+	DailyQuota int       `yaml:"daily_quota"` // Requests per UTC day, 0 for no quota
+}
+
+// apiKeyState is an API key with its rate limiter and quota usage
+type apiKeyState struct {
+	APIKey
+	limiter *rate.Limiter
+	day     time.Time // UTC day the usage is counted for
+	used    int
+}
+
+// APIKeyStore authenticates API keys and enforces their rate limits and daily quotas
+type APIKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]*apiKeyState // By key hash
+}
+
+// LoadAPIKeys reads a YAML file with a list of API keys under "keys"
+func LoadAPIKeys(file string) (*APIKeyStore, error) {
+	keys, err := readAPIKeys(file)
+	if err != nil {
+		return nil, err
+	}
+	return &APIKeyStore{keys: keys}, nil
+}
+
+// Reload replaces the API keys with those of the file. Keys that are still in the file keep
+// their rate limiter state and quota usage, with the new rate, burst and quota applied
+func (s *APIKeyStore) Reload(file string) error {
+	keys, err := readAPIKeys(file)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, k := range keys {
+		if current, ok := s.keys[hash]; ok {
+			current.limiter.SetLimitAt(now, k.limiter.Limit())
+			current.limiter.SetBurstAt(now, k.limiter.Burst())
+			k.limiter, k.day, k.used = current.limiter, current.day, current.used
+		}
+	}
+	s.keys = keys
+	return nil
+}
+
+// readAPIKeys reads the API keys of a file, by key hash
+func readAPIKeys(file string) (map[string]*apiKeyState, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Keys []APIKey `yaml:"keys"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	keys := make(map[string]*apiKeyState)
+	for _, key := range config.Keys {
+		hash := strings.ToLower(key.Hash)
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%s: key %q: hash must be a hex SHA-256", file, key.ID)
+		}
+		if key.ID == "" {
+			return nil, fmt.Errorf("%s: key without id", file)
+		}
+		limit, burst := rate.Inf, key.Burst
+		if key.Rate > 0 {
+			limit = rate.Limit(key.Rate)
+			burst = max(burst, 1)
+		}
+		keys[hash] = &apiKeyState{APIKey: key, limiter: rate.NewLimiter(limit, burst)}
+	}
+	return keys, nil
+}
+
+// Authenticate returns the identity of an API key and counts the request against
+// its rate limit and daily quota. The identity's roles are the key's scopes
+func (s *APIKeyStore) Authenticate(key string) (Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	k, err := s.lookup(key, now)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	day := now.UTC().Truncate(24 * time.Hour)
+	if !k.day.Equal(day) {
+		k.day, k.used = day, 0
+	}
+	if k.DailyQuota > 0 && k.used >= k.DailyQuota {
+		return Identity{}, resourceExhausted("daily quota exceeded", day.Add(24*time.Hour).Sub(now))
+	}
+
+	reservation := k.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return Identity{}, resourceExhausted("rate limit exceeded", delay)
+	}
+	k.used++
+
+	return k.identity(), nil
+}
+
+// Check returns the identity of an API key if it is still valid, without counting a request.
+// It rechecks the keys of long-lived connections, which counted a request when they opened
+func (s *APIKeyStore) Check(key string) (Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.lookup(key, time.Now())
+	if err != nil {
+		return Identity{}, err
+	}
+	return k.identity(), nil
+}
+
+// lookup returns the state of an API key that has not expired at now. The caller must hold s.mu
+func (s *APIKeyStore) lookup(key string, now time.Time) (*apiKeyState, error) {
+	sum := sha256.Sum256([]byte(key))
+	k, ok := s.keys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	if !k.Expires.IsZero() && now.After(k.Expires) {
+		return nil, ErrAPIKeyExpired
+	}
+	return k, nil
+}
+
+// identity returns the identity of the callers using the key
+func (k *apiKeyState) identity() Identity {
+	return Identity{Subject: "apikey:" + k.ID, Roles: k.Scopes, Expires: k.Expires}
+}
+
+// resourceExhausted returns a ResourceExhausted error telling the client when to retry
+func resourceExhausted(msg string, retryDelay time.Duration) error {
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryDelay)})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
+
+// retryDelay returns the retry delay of an error with retry information
+func retryDelay(err error) (time.Duration, bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
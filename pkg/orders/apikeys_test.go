@@ -0,0 +1,188 @@
+package orders
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// writeAPIKeys writes an API keys file holding the hashes of the keys, with their other fields in YAML
+func writeAPIKeys(t *testing.T, keys map[string]string) string {
+	t.Helper()
+	data := "keys:\n"
+	for key, fields := range keys {
+		sum := sha256.Sum256([]byte(key))
+		data += "  - hash: " + hex.EncodeToString(sum[:]) + "\n    " + fields + "\n"
+	}
+	file := filepath.Join(t.TempDir(), "apikeys.yaml")
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestAPIKeyStoreCheck(t *testing.T) {
+	store, err := LoadAPIKeys(writeAPIKeys(t, map[string]string{
+		"k1": "id: partner\n    scopes: [customer]\n    rate: 1\n    daily_quota: 1",
+		"k2": "id: expired\n    expires: 2020-01-01T00:00:00Z",
+	}))
+	if err != nil {
+		t.Fatalf("LoadAPIKeys() error = %v", err)
+	}
+
+	if _, err := store.Authenticate("k1"); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	// The quota and the rate limit are used up, but checking the key counts no request
+	for i := 0; i < 3; i++ {
+		id, err := store.Check("k1")
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		if id.Subject != "apikey:partner" || len(id.Roles) != 1 || id.Roles[0] != "customer" {
+			t.Errorf("Check() = %+v, want apikey:partner with the customer role", id)
+		}
+	}
+	if _, err := store.Authenticate("k1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Authenticate() over the quota error = %v, want ResourceExhausted", err)
+	}
+
+	if _, err := store.Check("k2"); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Check() of an expired key error = %v, want %v", err, ErrAPIKeyExpired)
+	}
+	if _, err := store.Check("unknown"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Check() of an unknown key error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
+
+func TestAPIKeyStoreAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   string
+		allowed  int           // Requests allowed in a row
+		maxDelay time.Duration // Bound of the retry delay of the next request
+	}{
+		{name: "rate limit", fields: "id: limited\n    rate: 1\n    burst: 2", allowed: 2, maxDelay: time.Second},
+		{name: "daily quota", fields: "id: quota\n    daily_quota: 3", allowed: 3, maxDelay: 24 * time.Hour},
+		{name: "quota before the rate limit", fields: "id: both\n    rate: 1\n    burst: 5\n    daily_quota: 1", allowed: 1, maxDelay: 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := LoadAPIKeys(writeAPIKeys(t, map[string]string{"k1": tt.fields}))
+			if err != nil {
+				t.Fatalf("LoadAPIKeys() error = %v", err)
+			}
+			for i := 0; i < tt.allowed; i++ {
+				if _, err := store.Authenticate("k1"); err != nil {
+					t.Fatalf("Authenticate() #%d error = %v", i+1, err)
+				}
+			}
+			_, err = store.Authenticate("k1")
+			if status.Code(err) != codes.ResourceExhausted {
+				t.Fatalf("Authenticate() over the limit error = %v, want ResourceExhausted", err)
+			}
+			if delay, ok := retryDelay(err); !ok || delay <= 0 || delay > tt.maxDelay {
+				t.Errorf("retry delay = %v, %v, want in (0, %v]", delay, ok, tt.maxDelay)
+			}
+		})
+	}
+
+	store, err := LoadAPIKeys(writeAPIKeys(t, map[string]string{"k1": "id: expired\n    expires: 2020-01-01T00:00:00Z"}))
+	if err != nil {
+		t.Fatalf("LoadAPIKeys() error = %v", err)
+	}
+	if _, err := store.Authenticate("k1"); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("Authenticate() of an expired key error = %v, want %v", err, ErrAPIKeyExpired)
+	}
+	if _, err := store.Authenticate("k2"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate() of an unknown key error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
+
+func TestAPIKeyStoreReload(t *testing.T) {
+	store, err := LoadAPIKeys(writeAPIKeys(t, map[string]string{"k1": "id: partner\n    daily_quota: 1"}))
+	if err != nil {
+		t.Fatalf("LoadAPIKeys() error = %v", err)
+	}
+	if _, err := store.Authenticate("k1"); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	// The usage of the day is kept, with the new quota
+	if err := store.Reload(writeAPIKeys(t, map[string]string{"k1": "id: partner\n    daily_quota: 2", "k2": "id: new"})); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if _, err := store.Authenticate("k1"); err != nil {
+		t.Errorf("Authenticate() under the new quota error = %v", err)
+	}
+	if _, err := store.Authenticate("k1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Authenticate() over the new quota error = %v, want ResourceExhausted", err)
+	}
+	if _, err := store.Authenticate("k2"); err != nil {
+		t.Errorf("Authenticate() with a new key error = %v", err)
+	}
+
+	if err := store.Reload(writeAPIKeys(t, map[string]string{"k2": "id: new"})); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if _, err := store.Authenticate("k1"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate() with a removed key error = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
+
+func TestAPIKeyLimits(t *testing.T) {
+	keys, err := LoadAPIKeys(writeAPIKeys(t, map[string]string{"k1": "id: partner\n    scopes: [customer]\n    daily_quota: 1"}))
+	if err != nil {
+		t.Fatalf("LoadAPIKeys() error = %v", err)
+	}
+	auth, err := NewAuthenticator(newTestSigner(t, "k1").path, testAudience, testIssuer, keys)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	t.Run("REST", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/", auth.Middleware, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		for _, want := range []int{http.StatusNoContent, http.StatusTooManyRequests} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(APIKeyHeader, "k1")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != want {
+				t.Fatalf("status = %d, want %d", rec.Code, want)
+			}
+			if want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After header missing")
+			}
+		}
+	})
+
+	t.Run("gRPC", func(t *testing.T) {
+		// The REST request counted one request of the day, and the rejected one none
+		if err := keys.Reload(writeAPIKeys(t, map[string]string{"k1": "id: partner\n    scopes: [customer]\n    daily_quota: 2"})); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyMetadata, "k1"))
+		info := &grpc.UnaryServerInfo{FullMethod: "/orders.OrderService/List"}
+		handler := func(ctx context.Context, req any) (any, error) { return nil, nil }
+		if _, err := auth.UnaryInterceptor(ctx, nil, info, handler); err != nil {
+			t.Fatalf("UnaryInterceptor() error = %v", err)
+		}
+		if _, err := auth.UnaryInterceptor(ctx, nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("UnaryInterceptor() over the quota error = %v, want ResourceExhausted", err)
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package orders
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// jwksCheckInterval is how often the JWKS file is checked for changes
+	jwksCheckInterval = 10 * time.Second
+	// jwksMinRefreshInterval limits the refreshes triggered by tokens with an unknown key id
+	jwksMinRefreshInterval = time.Second
+	// tokenLeeway is the clock skew tolerated when checking token expiry
+	tokenLeeway = 30 * time.Second
+)
+
+var (
+	ErrMissingToken = status.Error(codes.Unauthenticated, "missing bearer token")
+	ErrInvalidToken = status.Error(codes.Unauthenticated, "invalid bearer token")
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Subject string
+	Issuer  string
+	Roles   []string
+	Expires time.Time // When the credentials expire, zero if they do not
+}
+
+type identityCtx struct{}
+
+// withIdentity returns a copy of ctx carrying the caller identity
+func withIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityCtx{}, id)
+}
+
+// IdentityFromContext returns the authenticated caller of the request
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityCtx{}).(Identity)
+	return id, ok
+}
+
+// claims are the JWT claims read by the Authenticator
+type claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Authenticator verifies JWT bearer tokens against the keys of a local JWKS file,
+// or API keys when the request carries one. The JWKS file is reloaded when it changes,
+// so keys can be rotated without a restart
+type Authenticator struct {
+	path     string
+	audience string       // Audience the tokens must be issued for
+	issuer   string       // Issuer of the tokens
+	apiKeys  *APIKeyStore // nil when API keys are not accepted
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewAuthenticator creates an Authenticator using the keys of the JWKS file at path,
+// requiring the aud and iss claims of the tokens to match audience and issuer, which are both required,
+// and, if apiKeys is not nil, accepting its API keys
+func NewAuthenticator(path, audience, issuer string, apiKeys *APIKeyStore) (*Authenticator, error) {
+	if audience == "" || issuer == "" {
+		return nil, errors.New("the audience and the issuer of the tokens are required")
+	}
+	a := &Authenticator{path: path, audience: audience, issuer: issuer, apiKeys: apiKeys}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload reads the JWKS file if it changed since it was last read. The caller must hold a.mu
+// unless the Authenticator is not shared yet
+func (a *Authenticator) reload() error {
+	a.lastCheck = time.Now()
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	if a.keys != nil && info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.path, err)
+	}
+	a.keys = keys
+	a.modTime = info.ModTime()
+	return nil
+}
+
+// key returns the public key with the given key id. The JWKS file is checked for changes
+// periodically, and right away (rate limited) when the key id is unknown
+func (a *Authenticator) key(kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	since := time.Since(a.lastCheck)
+	if _, known := a.keys[kid]; since > jwksCheckInterval || (!known && since > jwksMinRefreshInterval) {
+		// Keep the current keys if the file is being rewritten
+		_ = a.reload()
+	}
+
+	key, ok := a.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// Verify verifies a token and returns the identity of its subject
+func (a *Authenticator) Verify(token string) (Identity, error) {
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.key(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+		jwt.WithAudience(a.audience),
+		jwt.WithIssuer(a.issuer),
+	)
+	if err != nil || c.Subject == "" {
+		return Identity{}, ErrInvalidToken
+	}
+	return Identity{Subject: c.Subject, Issuer: c.Issuer, Roles: c.Roles, Expires: c.ExpiresAt.Add(tokenLeeway)}, nil
+}
+
+// authenticate verifies the API key, or the bearer token of an Authorization
+// header value if there is no API key, and returns ctx carrying the caller identity
+func (a *Authenticator) authenticate(ctx context.Context, authorization, apiKey string) (context.Context, error) {
+	if apiKey != "" {
+		if a.apiKeys == nil {
+			return nil, ErrInvalidAPIKey
+		}
+		id, err := a.apiKeys.Authenticate(apiKey)
+		if err != nil {
+			return nil, err
+		}
+		return withIdentity(ctx, id), nil
+	}
+
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrMissingToken
+	}
+	id, err := a.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return withIdentity(ctx, id), nil
+}
+
+// reauthenticate checks again the credentials of a connection authenticated when it opened.
+// Unlike authenticate, it does not count a request against the rate limit and quota of an API key
+func (a *Authenticator) reauthenticate(ctx context.Context, authorization, apiKey string) (context.Context, error) {
+	if apiKey == "" {
+		return a.authenticate(ctx, authorization, "")
+	}
+	if a.apiKeys == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	id, err := a.apiKeys.Check(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return withIdentity(ctx, id), nil
+}
+
+// authenticateIncoming authenticates the caller from the incoming gRPC metadata
+func (a *Authenticator) authenticateIncoming(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	return a.authenticate(ctx, first("authorization"), first(APIKeyMetadata))
+}
+
+// UnaryInterceptor rejects unary calls without a valid bearer token or API key, except to public methods
+func (a *Authenticator) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	ctx, err := a.authenticateIncoming(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor rejects streaming calls without a valid bearer token or API key, except to public methods
+func (a *Authenticator) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if publicMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+	ctx, err := a.authenticateIncoming(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// Middleware rejects REST requests without a valid bearer token or API key with 401 Unauthorized,
+// and API keys over their rate limit or quota with 429 Too Many Requests
+func (a *Authenticator) Middleware(c *gin.Context) {
+	ctx, err := a.authenticate(c.Request.Context(), c.GetHeader("Authorization"), c.GetHeader(APIKeyHeader))
+	if err != nil {
+		if status.Code(err) == codes.Unauthenticated {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		}
+		writeError(c, err)
+		c.Abort()
+		return
+	}
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// contextStream is a grpc.ServerStream with a replaced context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the replaced context of the stream
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// jwk is a JSON Web Key. Only the members of public RSA, EC and Ed25519 keys are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the signature keys of a JSON Web Key Set, by key id
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signature keys in JWKS")
+	}
+	return keys, nil
+}
+
+// publicKey decodes the public key of a JWK
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
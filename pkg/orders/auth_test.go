@@ -0,0 +1,168 @@
+package orders
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testAudience = "orders-api"
+	testIssuer   = "https://auth.example.com/"
+)
+
+// testSigner signs tokens with EC keys published in a JWKS file
+type testSigner struct {
+	t    *testing.T
+	path string
+	keys map[string]*ecdsa.PrivateKey
+}
+
+// newTestSigner creates a JWKS file with a key for each key id
+func newTestSigner(t *testing.T, kids ...string) *testSigner {
+	s := &testSigner{t: t, path: filepath.Join(t.TempDir(), "jwks.json"), keys: make(map[string]*ecdsa.PrivateKey)}
+	s.addKeys(kids...)
+	return s
+}
+
+// addKeys adds keys to the JWKS file and moves its modification time forward, so that it is read again
+func (s *testSigner) addKeys(kids ...string) {
+	for _, kid := range kids {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		s.keys[kid] = key
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	for kid, key := range s.keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "EC", Kid: kid, Crv: "P-256",
+			X: enc(key.X.FillBytes(make([]byte, 32))), Y: enc(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err := os.WriteFile(s.path, data, 0o600); err != nil {
+		s.t.Fatal(err)
+	}
+	later := time.Now().Add(time.Duration(len(s.keys)) * time.Second)
+	if err := os.Chtimes(s.path, later, later); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// sign returns a token signed with the key kid, with the claims of a valid token changed by edit
+func (s *testSigner) sign(kid string, edit func(jwt.MapClaims)) string {
+	claims := jwt.MapClaims{
+		"sub":   "alice",
+		"aud":   testAudience,
+		"iss":   testIssuer,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"customer"},
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(s.keys[kid])
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAuthenticatorVerify(t *testing.T) {
+	signer := newTestSigner(t, "k1")
+	auth, err := NewAuthenticator(signer.path, testAudience, testIssuer, nil)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		edit  func(jwt.MapClaims)
+		valid bool
+	}{
+		{name: "valid", valid: true},
+		{name: "audience in a list", edit: func(c jwt.MapClaims) { c["aud"] = []string{"other", testAudience} }, valid: true},
+		{name: "other audience", edit: func(c jwt.MapClaims) { c["aud"] = "other" }},
+		{name: "no audience", edit: func(c jwt.MapClaims) { delete(c, "aud") }},
+		{name: "other issuer", edit: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com/" }},
+		{name: "no issuer", edit: func(c jwt.MapClaims) { delete(c, "iss") }},
+		{name: "expired within the leeway", edit: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-tokenLeeway / 2).Unix() }, valid: true},
+		{name: "expired", edit: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * tokenLeeway).Unix() }},
+		{name: "no expiry", edit: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "no subject", edit: func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := auth.Verify(signer.sign("k1", tt.edit))
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("Verify() = %+v, %v, want %v", id, err, ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if id.Subject != "alice" || id.Issuer != testIssuer || len(id.Roles) != 1 || id.Roles[0] != "customer" {
+				t.Errorf("Verify() = %+v, want alice, customer", id)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorKeyRotation(t *testing.T) {
+	signer := newTestSigner(t, "k1")
+	auth, err := NewAuthenticator(signer.path, testAudience, testIssuer, nil)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	signer.addKeys("k2")
+	token := signer.sign("k2", nil)
+	// An unknown key id reads the file again, at most once per jwksMinRefreshInterval
+	if _, err := auth.Verify(token); err == nil {
+		t.Error("Verify() with a key added right after the last read succeeded, want the refresh to be rate limited")
+	}
+	auth.mu.Lock()
+	auth.lastCheck = time.Now().Add(-jwksMinRefreshInterval)
+	auth.mu.Unlock()
+	if _, err := auth.Verify(token); err != nil {
+		t.Errorf("Verify() with a new key error = %v, want the JWKS file read again", err)
+	}
+	if _, err := auth.Verify(signer.sign("k1", nil)); err != nil {
+		t.Errorf("Verify() with the previous key error = %v", err)
+	}
+
+	if _, err := auth.Verify(newTestSigner(t, "k1").sign("k1", nil)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with a token signed by another key error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestNewAuthenticatorRequiresClaims(t *testing.T) {
+	signer := newTestSigner(t, "k1")
+	for _, claims := range [][2]string{{"", testIssuer}, {testAudience, ""}} {
+		if _, err := NewAuthenticator(signer.path, claims[0], claims[1], nil); err == nil {
+			t.Errorf("NewAuthenticator(audience %q, issuer %q) error = nil, want an error", claims[0], claims[1])
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package orders
+
+import (
+	"sync"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+)
+
+// changeFeedRetention is the number of changes kept for subscribers that resume after a disconnection
+const changeFeedRetention = 1024
+
+// Kinds of order changes
+const (
+	OrderCreated       = "created"
+	OrderStatusChanged = "status"
+	OrderUpdated       = "updated" // Changes of other fields than the status
+)
+
+// OrderChange is a change of an order in the store. The orders are shared between the subscribers
+// and must not be modified
+type OrderChange struct {
+	Seq      int64        // Position in the feed, from 1
+	Order    *proto.Order // The order after the change
+	Previous *proto.Order // The order before the change, nil for a creation
+}
+
+// Kind returns the kind of the change: OrderCreated, OrderStatusChanged or OrderUpdated
+func (c OrderChange) Kind() string {
+	switch {
+	case c.Previous == nil:
+		return OrderCreated
+	case c.Previous.Status != c.Order.Status:
+		return OrderStatusChanged
+	default:
+		return OrderUpdated
+	}
+}
+
+// ChangeFeed is the sequence of the changes of the orders of a store. It keeps the latest changes,
+// so that subscribers can resume after a disconnection, and wakes the subscribers up on each change.
+// Subscribers read at their own pace: one that falls behind the kept changes is told it missed some
+type ChangeFeed struct {
+	mu      sync.Mutex
+	changes []OrderChange // The latest changes, at most changeFeedRetention
+	lastSeq int64
+	next    chan struct{} // Closed on the next change
+}
+
+// newChangeFeed creates an empty ChangeFeed
+func newChangeFeed() *ChangeFeed {
+	return &ChangeFeed{next: make(chan struct{})}
+}
+
+// publish appends the change of an order to the feed
+func (f *ChangeFeed) publish(order, previous *proto.Order) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastSeq++
+	f.changes = append(f.changes, OrderChange{Seq: f.lastSeq, Order: order, Previous: previous})
+	if len(f.changes) > changeFeedRetention {
+		f.changes = f.changes[1:]
+	}
+	close(f.next)
+	f.next = make(chan struct{})
+}
+
+// Last returns the position of the latest change, 0 if there is none
+func (f *ChangeFeed) Last() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastSeq
+}
+
+// Since returns the changes after the position seq and a channel closed on the next change.
+// It returns false if changes after seq are no longer kept, or if seq is ahead of the feed,
+// as after a restart of the service
+func (f *ChangeFeed) Since(seq int64) ([]OrderChange, <-chan struct{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if seq > f.lastSeq {
+		return nil, f.next, false
+	}
+	first := f.lastSeq - int64(len(f.changes)) + 1
+	if seq+1 < first {
+		return nil, f.next, false
+	}
+	return f.changes[seq+1-first:], f.next, true
+}
@@ -0,0 +1,102 @@
+package orders
+
+import (
+	"testing"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+)
+
+// seqs returns the positions of changes
+func seqs(changes []OrderChange) []int64 {
+	s := make([]int64, len(changes))
+	for i, change := range changes {
+		s[i] = change.Seq
+	}
+	return s
+}
+
+func TestChangeFeedSince(t *testing.T) {
+	feed := newChangeFeed()
+	for i := 0; i < changeFeedRetention+5; i++ {
+		feed.publish(&proto.Order{OrderId: int64(i)}, nil)
+	}
+	last := int64(changeFeedRetention + 5)
+
+	tests := []struct {
+		name  string
+		seq   int64
+		first int64 // Position of the first change returned, 0 for none
+		ok    bool
+	}{
+		{name: "up to date", seq: last, ok: true},
+		{name: "resume", seq: last - 3, first: last - 2, ok: true},
+		{name: "oldest kept", seq: 5, first: 6, ok: true},
+		{name: "no longer kept", seq: 4, ok: false},
+		{name: "from the start", seq: 0, ok: false},
+		{name: "ahead of the feed", seq: last + 1, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, next, ok := feed.Since(tt.seq)
+			if ok != tt.ok {
+				t.Fatalf("Since(%d) ok = %v, want %v", tt.seq, ok, tt.ok)
+			}
+			if next == nil {
+				t.Errorf("Since(%d) returned no next channel", tt.seq)
+			}
+			if !ok {
+				return
+			}
+			if tt.first == 0 {
+				if len(changes) != 0 {
+					t.Errorf("Since(%d) = %v, want no changes", tt.seq, seqs(changes))
+				}
+				return
+			}
+			got := seqs(changes)
+			if len(got) != int(last-tt.first+1) || got[0] != tt.first || got[len(got)-1] != last {
+				t.Errorf("Since(%d) = changes %d to %d, want %d to %d", tt.seq, got[0], got[len(got)-1], tt.first, last)
+			}
+		})
+	}
+}
+
+func TestChangeFeedResetFromEmpty(t *testing.T) {
+	feed := newChangeFeed()
+	if changes, _, ok := feed.Since(0); !ok || len(changes) != 0 {
+		t.Errorf("Since(0) on an empty feed = %v, %v, want no changes", seqs(changes), ok)
+	}
+	// After a restart, positions of the previous process are ahead of the feed
+	if _, _, ok := feed.Since(3); ok {
+		t.Errorf("Since(3) on an empty feed ok = true, want a reset")
+	}
+}
+
+func TestChangeFeedWakesUp(t *testing.T) {
+	feed := newChangeFeed()
+	_, next, _ := feed.Since(0)
+	select {
+	case <-next:
+		t.Fatal("next closed before a change")
+	default:
+	}
+
+	order := &proto.Order{OrderId: 1, Status: proto.Order_PENDING}
+	feed.publish(order, nil)
+	select {
+	case <-next:
+	default:
+		t.Fatal("next not closed after a change")
+	}
+
+	changes, next, ok := feed.Since(0)
+	if !ok || len(changes) != 1 || changes[0].Kind() != OrderCreated {
+		t.Fatalf("Since(0) = %v, %v, want the creation", changes, ok)
+	}
+	paid := &proto.Order{OrderId: 1, Status: proto.Order_PAID}
+	feed.publish(paid, order)
+	<-next
+	if changes, _, _ := feed.Since(1); len(changes) != 1 || changes[0].Kind() != OrderStatusChanged {
+		t.Errorf("Since(1) = %v, want the status change", changes)
+	}
+}
@@ -0,0 +1,310 @@
+package orders
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+)
+
+// restCodecKey is the gin context key of the codec of the response of a REST request
+const restCodecKey = "rest.codec"
+
+// restCodec encodes and decodes the messages of the REST API in a media type
+type restCodec struct {
+	mediaType string
+	aliases   []string // Other media types accepted for the codec
+	marshal   func(protobuf.Message) ([]byte, error)
+	// unmarshal decodes a message, rejecting unknown fields unless discardUnknown is set
+	unmarshal func(data []byte, msg protobuf.Message, discardUnknown bool) error
+	// marshalError encodes the body of an error response
+	marshalError func(*status.Status) ([]byte, error)
+}
+
+var (
+	// jsonCodec encodes messages with protojson, and errors as {"error": message}
+	jsonCodec = &restCodec{
+		mediaType: "application/json",
+		marshal:   protojson.Marshal,
+		unmarshal: unmarshalJSON,
+		marshalError: func(st *status.Status) ([]byte, error) {
+			return json.Marshal(gin.H{"error": st.Message()})
+		},
+	}
+	// protobufCodec encodes messages in the binary protobuf format, and errors as google.rpc.Status
+	protobufCodec = &restCodec{
+		mediaType: "application/x-protobuf",
+		aliases:   []string{"application/protobuf", "application/vnd.google.protobuf"},
+		marshal:   protobuf.Marshal,
+		unmarshal: unmarshalProtobuf,
+		marshalError: func(st *status.Status) ([]byte, error) {
+			return protobuf.Marshal(st.Proto())
+		},
+	}
+	// yamlCodec encodes messages as the YAML form of their protojson encoding, and errors as error: message
+	yamlCodec = &restCodec{
+		mediaType: "application/yaml",
+		aliases:   []string{"application/x-yaml", "text/yaml"},
+		marshal:   marshalYAML,
+		unmarshal: unmarshalYAML,
+		marshalError: func(st *status.Status) ([]byte, error) {
+			return yaml.Marshal(gin.H{"error": st.Message()})
+		},
+	}
+)
+
+// restCodecs are the codecs of the REST API, by order of preference when the client accepts several
+var restCodecs = []*restCodec{jsonCodec, protobufCodec, yamlCodec}
+
+// requestCodec returns the codec of a request body with the given Content-Type.
+// Bodies without a content type are JSON
+func requestCodec(contentType string) (*restCodec, bool) {
+	if contentType == "" {
+		return jsonCodec, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	for _, codec := range restCodecs {
+		if codec.is(mediaType) {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// responseCodec returns the codec of the response with the highest quality in an Accept header,
+// the first one of restCodecs among equals. Without Accept header the response is JSON
+func responseCodec(accept string) (*restCodec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return jsonCodec, true
+	}
+	var best *restCodec
+	var bestQuality float64
+	for _, codec := range restCodecs {
+		if q := codec.quality(accept); q > bestQuality {
+			best, bestQuality = codec, q
+		}
+	}
+	return best, best != nil
+}
+
+// codecOf returns the codec of the response of a request. Before it was negotiated, such as for the errors
+// of the middlewares and of unknown routes, it is the codec the Accept header prefers, JSON if none is acceptable
+func codecOf(c *gin.Context) *restCodec {
+	if codec, ok := c.Get(restCodecKey); ok {
+		return codec.(*restCodec)
+	}
+	if codec, ok := responseCodec(c.GetHeader("Accept")); ok {
+		return codec
+	}
+	return jsonCodec
+}
+
+// mediaTypes returns the media types of the codecs, for error messages
+func mediaTypes() string {
+	types := make([]string, len(restCodecs))
+	for i, codec := range restCodecs {
+		types[i] = codec.mediaType
+	}
+	return strings.Join(types, ", ")
+}
+
+// is tells whether a media type is the one of the codec
+func (rc *restCodec) is(mediaType string) bool {
+	if strings.EqualFold(mediaType, rc.mediaType) {
+		return true
+	}
+	for _, alias := range rc.aliases {
+		if strings.EqualFold(mediaType, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// quality returns the quality of the codec in an Accept header: the q parameter of the most specific
+// media range matching it, 0 if none does
+func (rc *restCodec) quality(accept string) float64 {
+	quality, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		s := rc.specificity(mediaType)
+		if s <= specificity {
+			continue
+		}
+		quality, specificity = 1, s
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				quality = 0
+			}
+		}
+	}
+	return quality
+}
+
+// specificity returns how a media range matches the codec: 2 for one of its media types,
+// 1 for the type of one of them with any subtype, 0 for any media type and -1 if it does not match
+func (rc *restCodec) specificity(mediaRange string) int {
+	switch {
+	case mediaRange == "*/*":
+		return 0
+	case rc.is(mediaRange):
+		return 2
+	}
+	anySubtype, ok := strings.CutSuffix(mediaRange, "/*")
+	if !ok {
+		return -1
+	}
+	for _, mediaType := range append([]string{rc.mediaType}, rc.aliases...) {
+		if t, _, _ := strings.Cut(mediaType, "/"); strings.EqualFold(t, anySubtype) {
+			return 1
+		}
+	}
+	return -1
+}
+
+// marshalYAML encodes a message as the YAML form of its protojson encoding, keeping the order of the fields
+func marshalYAML(msg protobuf.Message) ([]byte, error) {
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+	return yaml.Marshal(&node)
+}
+
+// blockStyle drops the JSON styles of a YAML node decoded from JSON: flow collections and quoted strings.
+// Strings that would read as other types stay quoted, as their tags are kept
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// unmarshalJSON decodes a message with protojson. Syntax errors are returned as a *syntaxError
+func unmarshalJSON(data []byte, msg protobuf.Message, discardUnknown bool) error {
+	err := protojson.UnmarshalOptions{DiscardUnknown: discardUnknown}.Unmarshal(data, msg)
+	if err == nil {
+		return nil
+	}
+	// protojson reports a syntax error in a value as an invalid value, and the end of the input without a position.
+	// encoding/json tells syntax errors apart and locates them all, so a body protojson rejects is parsed again
+	var syntax *json.SyntaxError
+	if errors.As(json.Unmarshal(data, new(any)), &syntax) {
+		return newSyntaxError(data, syntax.Offset, syntax)
+	}
+	return err
+}
+
+// unmarshalProtobuf decodes a binary protobuf message. The binary format keeps unknown fields
+// in the message: they are dropped if discardUnknown is set and rejected otherwise, like in JSON
+func unmarshalProtobuf(data []byte, msg protobuf.Message, discardUnknown bool) error {
+	if err := (protobuf.UnmarshalOptions{DiscardUnknown: discardUnknown}).Unmarshal(data, msg); err != nil {
+		return err
+	}
+	if discardUnknown {
+		return nil
+	}
+	return checkUnknownFields(msg.ProtoReflect())
+}
+
+// checkUnknownFields returns an error naming the first unknown field of m or of the messages it holds
+func checkUnknownFields(m protoreflect.Message) error {
+	if unknown := m.GetUnknown(); len(unknown) > 0 {
+		num, _, _ := protowire.ConsumeTag(unknown)
+		return fmt.Errorf("unknown field %d in %s", num, m.Descriptor().FullName())
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				err = checkUnknownFields(v.Message())
+				return err == nil
+			})
+		case fd.IsMap() || fd.Message() == nil:
+			// Scalar values hold no unknown fields
+		case fd.IsList():
+			for i := 0; i < v.List().Len() && err == nil; i++ {
+				err = checkUnknownFields(v.List().Get(i).Message())
+			}
+		default:
+			err = checkUnknownFields(v.Message())
+		}
+		return err == nil
+	})
+	return err
+}
+
+// unmarshalYAML decodes a message from YAML with the fields of its protojson encoding
+func unmarshalYAML(data []byte, msg protobuf.Message, discardUnknown bool) error {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil // Only comments
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := unmarshalJSON(data, msg, discardUnknown); err != nil {
+		// Any location protojson gives is in the single line JSON form, not in the YAML body
+		return fmt.Errorf("in the JSON form of the body: %w", err)
+	}
+	return nil
+}
+
+// syntaxError is a syntax error at a known location of a request body
+type syntaxError struct {
+	line, column int
+	err          error
+}
+
+// newSyntaxError returns the syntax error err found after offset bytes of data
+func newSyntaxError(data []byte, offset int64, err error) *syntaxError {
+	before := data[:min(max(offset, 0), int64(len(data)))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := utf8.RuneCount(before[bytes.LastIndexByte(before, '\n')+1:])
+	return &syntaxError{line: line, column: max(column, 1), err: err}
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.line, e.column, e.err)
+}
+
+func (e *syntaxError) Unwrap() error { return e.err }
+
+// bodyError returns the InvalidArgument error of a request body that could not be decoded,
+// with the location of the error in the body if known
+func bodyError(err error) error {
+	var syntax *syntaxError
+	if errors.As(err, &syntax) {
+		return status.Errorf(codes.InvalidArgument, "invalid request body at line %d, column %d: %v", syntax.line, syntax.column, syntax.err)
+	}
+	return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
+}
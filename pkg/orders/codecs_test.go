@@ -0,0 +1,116 @@
+package orders
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+func TestResponseCodec(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   *restCodec // nil if no codec is acceptable
+	}{
+		{"", jsonCodec},
+		{"*/*", jsonCodec},
+		{"application/json", jsonCodec},
+		{"application/x-protobuf", protobufCodec},
+		{"application/protobuf", protobufCodec},
+		{"application/vnd.google.protobuf", protobufCodec},
+		{"application/yaml", yamlCodec},
+		{"text/yaml", yamlCodec},
+		{"APPLICATION/X-YAML", yamlCodec},
+		{"text/html", nil},
+		{"text/html, application/yaml", yamlCodec},
+		{"application/json;q=0.5, application/x-protobuf", protobufCodec},
+		{"application/json;q=0.5, application/yaml;q=0.9", yamlCodec},
+		{"application/*", jsonCodec},
+		{"text/*", yamlCodec},
+		{"*/*;q=0.1, application/x-protobuf", protobufCodec},
+		{"application/json;q=0, */*", protobufCodec},
+		{"*/*, application/json;q=0", protobufCodec},
+		{"application/json;q=0", nil},
+		{"application/json;q=bad", nil},
+		{"application/json, application/yaml", jsonCodec},
+		{"application/yaml, application/json", jsonCodec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got, ok := responseCodec(tt.accept)
+			if ok != (tt.want != nil) || got != tt.want {
+				t.Errorf("responseCodec() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestCodec(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        *restCodec // nil if the content type is not supported
+	}{
+		{"", jsonCodec},
+		{"application/json", jsonCodec},
+		{"application/json; charset=utf-8", jsonCodec},
+		{"application/x-protobuf", protobufCodec},
+		{"application/x-yaml", yamlCodec},
+		{"text/plain", nil},
+		{"application/json; charset", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			got, ok := requestCodec(tt.contentType)
+			if ok != (tt.want != nil) || got != tt.want {
+				t.Errorf("requestCodec() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestBodyError(t *testing.T) {
+	tests := []struct {
+		body string
+		want string // Prefix of the error message
+	}{
+		{`{"orderId": }`, "invalid request body at line 1, column 13: invalid character '}'"},
+		{"{\n  \"orderId\": 1,\n  ]\n}", "invalid request body at line 3, column 3: invalid character ']'"},
+		{`{"orderId": 1`, "invalid request body at line 1, column 13: unexpected end of JSON input"},
+		{`{"unknown": 1}`, "invalid request body: "},
+		{`{"orderId": "one"}`, "invalid request body: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			err := unmarshalJSON([]byte(tt.body), &proto.RetrieveOrderRequest{}, false)
+			if err == nil {
+				t.Fatal("unmarshalJSON() error = nil, want an error")
+			}
+			if got := status.Convert(bodyError(err)).Message(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("bodyError() = %q, want prefix %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalProtobufUnknownFields(t *testing.T) {
+	item, _ := protobuf.Marshal(&proto.Item{Description: "a", Price: 1})
+	item = protowire.AppendTag(item, 99, protowire.VarintType)
+	item = protowire.AppendVarint(item, 1)
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.BytesType) // CreateOrderRequest.items
+	body = protowire.AppendBytes(body, item)
+
+	if err := unmarshalProtobuf(body, &proto.CreateOrderRequest{}, false); err == nil || !strings.Contains(err.Error(), "unknown field 99") {
+		t.Errorf("unmarshalProtobuf() error = %v, want the unknown field of the item", err)
+	}
+	req := &proto.CreateOrderRequest{}
+	if err := unmarshalProtobuf(body, req, true); err != nil {
+		t.Fatalf("unmarshalProtobuf() discarding unknown fields error = %v", err)
+	}
+	if len(req.Items) != 1 || len(req.Items[0].ProtoReflect().GetUnknown()) != 0 {
+		t.Errorf("unmarshalProtobuf() discarding unknown fields = %v, want the item without them", req)
+	}
+}
@@ -0,0 +1,205 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
+	"connectrpc.com/connect"
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"github.com/AndreiMartynenko/grpc-eshop/proto/protoconnect"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// connectHandler serves the order and operations services over the Connect protocol (JSON or binary
+// protobuf over HTTP POST), so that browser clients can call every RPC, and poll the operations
+// of CreateAsync. Every call runs through the unary interceptors of the gRPC server,
+// with the request headers as incoming metadata, so authentication, the policy, idempotency keys,
+// logging and metrics work as for gRPC calls
+type connectHandler struct {
+	orderService proto.OrderServiceServer
+	operations   longrunningpb.OperationsServer
+	interceptors []grpc.UnaryServerInterceptor
+	mux          atomic.Pointer[http.ServeMux] // Rebuilt when the maximum message size changes
+}
+
+// newConnectHandler creates the handler serving the order and operations services over Connect,
+// with messages of at most maxBytes
+func newConnectHandler(orderService proto.OrderServiceServer, operations longrunningpb.OperationsServer, auth *Authenticator, policy *Policy, maxBytes int64) *connectHandler {
+	h := &connectHandler{
+		orderService: orderService,
+		operations:   operations,
+		interceptors: unaryInterceptors(auth, policy),
+	}
+	h.setReadMaxBytes(maxBytes)
+	return h
+}
+
+// setReadMaxBytes changes the size in bytes above which request messages are rejected, once decompressed
+func (h *connectHandler) setReadMaxBytes(maxBytes int64) {
+	opt := connect.WithReadMaxBytes(int(maxBytes))
+	mux := http.NewServeMux()
+	mux.Handle(protoconnect.NewOrderServiceHandler(connectOrderService{service: h.orderService, interceptors: h.interceptors}, opt))
+
+	ops := h.operations
+	handleUnary(mux, h.interceptors, ops, operationsGetFullMethodName, ops.GetOperation, opt)
+	handleUnary(mux, h.interceptors, ops, operationsListFullMethodName, ops.ListOperations, opt)
+	handleUnary(mux, h.interceptors, ops, operationsDeleteFullMethodName, ops.DeleteOperation, opt)
+	handleUnary(mux, h.interceptors, ops, operationsCancelFullMethodName, ops.CancelOperation, opt)
+	handleUnary(mux, h.interceptors, ops, operationsWaitFullMethodName, ops.WaitOperation, opt)
+	h.mux.Store(mux)
+}
+
+// serves tells whether a request path is the one of a Connect procedure
+func (h *connectHandler) serves(path string) bool {
+	return strings.HasPrefix(path, "/"+proto.OrderService_ServiceDesc.ServiceName+"/") ||
+		strings.HasPrefix(path, "/"+operationsServiceName+"/")
+}
+
+func (h *connectHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.Load().ServeHTTP(w, req)
+}
+
+// handleUnary serves a unary RPC of a service without generated Connect code on mux
+func handleUnary[Req, Res any](mux *http.ServeMux, interceptors []grpc.UnaryServerInterceptor, server any, method string, call func(context.Context, *Req) (*Res, error), opts ...connect.HandlerOption) {
+	mux.Handle(method, connect.NewUnaryHandler(method, func(ctx context.Context, req *connect.Request[Req]) (*connect.Response[Res], error) {
+		return callUnary(ctx, interceptors, server, method, req, call)
+	}, opts...))
+}
+
+// connectOrderService implements the generated Connect handler of the order service
+type connectOrderService struct {
+	service      proto.OrderServiceServer
+	interceptors []grpc.UnaryServerInterceptor
+}
+
+func (s connectOrderService) Create(ctx context.Context, req *connect.Request[proto.CreateOrderRequest]) (*connect.Response[proto.CreateOrderResponse], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_Create_FullMethodName, req, s.service.Create)
+}
+
+func (s connectOrderService) CreateAsync(ctx context.Context, req *connect.Request[proto.CreateOrderRequest]) (*connect.Response[longrunningpb.Operation], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_CreateAsync_FullMethodName, req, s.service.CreateAsync)
+}
+
+func (s connectOrderService) QuoteOrder(ctx context.Context, req *connect.Request[proto.QuoteOrderRequest]) (*connect.Response[proto.QuoteOrderResponse], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_QuoteOrder_FullMethodName, req, s.service.QuoteOrder)
+}
+
+func (s connectOrderService) Retrieve(ctx context.Context, req *connect.Request[proto.RetrieveOrderRequest]) (*connect.Response[proto.RetrieveOrderResponse], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_Retrieve_FullMethodName, req, s.service.Retrieve)
+}
+
+func (s connectOrderService) Update(ctx context.Context, req *connect.Request[proto.UpdateOrderRequest]) (*connect.Response[proto.UpdateOrderResponse], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_Update_FullMethodName, req, s.service.Update)
+}
+
+func (s connectOrderService) UpdateStatus(ctx context.Context, req *connect.Request[proto.UpdateOrderStatusRequest]) (*connect.Response[proto.UpdateOrderStatusResponse], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_UpdateStatus_FullMethodName, req, s.service.UpdateStatus)
+}
+
+func (s connectOrderService) Delete(ctx context.Context, req *connect.Request[proto.DeleteOrderRequest]) (*connect.Response[proto.DeleteOrderResponse], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_Delete_FullMethodName, req, s.service.Delete)
+}
+
+func (s connectOrderService) List(ctx context.Context, req *connect.Request[proto.ListOrderRequest]) (*connect.Response[proto.ListOrderResponse], error) {
+	return callUnary(ctx, s.interceptors, s.service, proto.OrderService_List_FullMethodName, req, s.service.List)
+}
+
+// callUnary calls a method of a service through the interceptors. Headers and trailers set
+// by the interceptors and the service are returned with the response, or with the error
+func callUnary[Req, Res any](ctx context.Context, interceptors []grpc.UnaryServerInterceptor, server any, method string, req *connect.Request[Req], call func(context.Context, *Req) (*Res, error)) (res *connect.Response[Res], err error) {
+	// Spans are started from the W3C trace context of the incoming headers
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(req.Header()))
+	ctx, span := tracer.Start(ctx, strings.TrimPrefix(method, "/"), trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	md := metadata.MD{}
+	for key, values := range req.Header() {
+		md.Append(key, values...)
+	}
+	stream := &connectTransportStream{method: method, header: metadata.MD{}, trailer: metadata.MD{}}
+	ctx = grpc.NewContextWithServerTransportStream(metadata.NewIncomingContext(ctx, md), stream)
+
+	info := &grpc.UnaryServerInfo{Server: server, FullMethod: method}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return call(ctx, req.(*Req))
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, req any) (any, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+
+	resp, err := handler(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(err, stream)
+	}
+	res = connect.NewResponse(resp.(*Res))
+	copyMetadata(res.Header(), stream.header)
+	copyMetadata(res.Trailer(), stream.trailer)
+	return res, nil
+}
+
+// connectError converts a gRPC status error to a Connect error with the same code, message and details
+func connectError(err error, stream *connectTransportStream) error {
+	st := status.Convert(err)
+	connectErr := connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, detail := range st.Proto().GetDetails() {
+		if d, err := connect.NewErrorDetail(detail); err == nil {
+			connectErr.AddDetail(d)
+		}
+	}
+	copyMetadata(connectErr.Meta(), stream.header)
+	copyMetadata(connectErr.Meta(), stream.trailer)
+	return connectErr
+}
+
+// copyMetadata adds gRPC metadata to HTTP headers
+func copyMetadata(header http.Header, md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+}
+
+// connectTransportStream collects the headers and trailers set with grpc.SetHeader and grpc.SetTrailer
+// during a Connect call
+type connectTransportStream struct {
+	method  string
+	mu      sync.Mutex
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *connectTransportStream) Method() string {
+	return s.method
+}
+
+func (s *connectTransportStream) SetHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *connectTransportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *connectTransportStream) SetTrailer(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
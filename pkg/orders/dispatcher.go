@@ -0,0 +1,176 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrDispatcherStopped = status.Error(codes.Unavailable, "the service is shutting down")
+
+// dispatchedOrder is an order waiting for fulfillment, with the context of the request that submitted it
+type dispatchedOrder struct {
+	ctx   context.Context
+	order *proto.Order
+}
+
+// OrderDispatcher is a daemon process that creates a set of handlers using sync.WaitGroup to concurrently
+// process and dispatch orders
+type OrderDispatcher struct {
+	ordersCh   chan dispatchedOrder
+	limiter    *orderLimiter   // bounds the number of orders the pool will process concurrently
+	submitting *sync.WaitGroup // orders submitted but not yet in the orders channel
+	done       chan struct{}   // closed once every order has been processed after Shutdown
+	running    *atomic.Bool    // true between Start and Shutdown
+	mu         *sync.Mutex     // orders the submissions with Shutdown
+	shutdown   *sync.Once      // closes the orders channel once
+}
+
+// NewOrderDispatcher creates a new OrderDispatcher
+func NewOrderDispatcher(orderLimit int, bufferSize int) OrderDispatcher {
+	return OrderDispatcher{
+		ordersCh:   make(chan dispatchedOrder, bufferSize),
+		limiter:    newOrderLimiter(orderLimit),
+		submitting: &sync.WaitGroup{},
+		done:       make(chan struct{}),
+		running:    &atomic.Bool{},
+		mu:         &sync.Mutex{},
+		shutdown:   &sync.Once{},
+	}
+}
+
+// Reserve admits an order before it is saved, so that an order is never saved without being processed.
+// It returns ErrDispatcherStopped after Shutdown, and otherwise the function submitting the order,
+// which Shutdown waits for and which must be called once. Submitting blocks while the queue is full,
+// slowing down the requests rather than failing them. The order is processed with the values
+// of ctx (such as the request ID), but is not cancelled with it
+func (d OrderDispatcher) Reserve() (submit func(ctx context.Context, order *proto.Order), err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.running.Load() {
+		return nil, ErrDispatcherStopped
+	}
+	d.submitting.Add(1)
+	return func(ctx context.Context, order *proto.Order) {
+		defer d.submitting.Done()
+		dispatcherQueueDepth.Inc()
+		d.ordersCh <- dispatchedOrder{ctx: context.WithoutCancel(ctx), order: order}
+	}, nil
+}
+
+// SetOrderLimit changes the maximum number of orders processed concurrently. Orders being
+// processed are not interrupted when the limit decreases; new orders wait for the count to drop
+func (d OrderDispatcher) SetOrderLimit(orderLimit int) {
+	d.limiter.setLimit(orderLimit)
+}
+
+// Start launches the dispatcher in the background
+func (d OrderDispatcher) Start() {
+	d.running.Store(true)
+	go d.processOrders()
+}
+
+// Ready returns an error if the dispatcher is not running, before Start or once Shutdown began.
+// A full queue does not make it unready, since submissions wait for room
+func (d OrderDispatcher) Ready() error {
+	if !d.running.Load() {
+		return errors.New("dispatcher is not running")
+	}
+	return nil
+}
+
+// Shutdown rejects new orders, waits for the submitted orders to enter the orders channel, closes it
+// and waits for the orders to be processed. Further calls wait for the first one
+func (d OrderDispatcher) Shutdown() {
+	d.shutdown.Do(func() {
+		d.mu.Lock()
+		d.running.Store(false)
+		d.mu.Unlock()
+		d.submitting.Wait()
+		close(d.ordersCh)
+		<-d.done
+	})
+}
+
+// processOrders processes all incoming orders in the background using
+// for-range and sync.WaitGroup
+func (d OrderDispatcher) processOrders() {
+	defer close(d.done)
+
+	var wg sync.WaitGroup
+
+	// Continuous processing of orders received from the orders channel
+	// This loop will exit after the channel is closed
+	for o := range d.ordersCh {
+		d.limiter.acquire()
+		dispatcherQueueDepth.Dec()
+		dispatcherInFlight.Inc()
+		wg.Add(1)
+
+		go func(o dispatchedOrder) {
+			// The fulfillment span is a child of the span of the request that submitted the order
+			ctx, span := tracer.Start(o.ctx, "fulfillOrder", trace.WithAttributes(attribute.Int64("order_id", o.order.OrderId)))
+			start := time.Now()
+			// What needs to be done: start the fulfillment process to pack and ship the order
+			// Currently using a sleep and log for demonstration
+			time.Sleep(50 * time.Millisecond)
+			slog.InfoContext(ctx, "order has shipped", "order_id", o.order.OrderId, "items", len(o.order.Items))
+			fulfillmentDuration.Observe(time.Since(start).Seconds())
+			span.End()
+			dispatcherInFlight.Dec()
+			d.limiter.release()
+			wg.Done()
+		}(o)
+	}
+	wg.Wait()
+}
+
+// orderLimiter is a counting semaphore whose limit can change while orders are processed
+type orderLimiter struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int
+	inUse int
+}
+
+// newOrderLimiter creates an orderLimiter allowing limit orders at once
+func newOrderLimiter(limit int) *orderLimiter {
+	l := &orderLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits until an order may be processed
+func (l *orderLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inUse >= l.limit {
+		l.cond.Wait()
+	}
+	l.inUse++
+}
+
+// release ends the processing of an order
+func (l *orderLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inUse--
+	l.cond.Signal()
+}
+
+// setLimit changes the number of orders allowed at once
+func (l *orderLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
@@ -0,0 +1,77 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+)
+
+func TestDispatcherReserve(t *testing.T) {
+	d := NewOrderDispatcher(1, 1)
+	if _, err := d.Reserve(); !errors.Is(err, ErrDispatcherStopped) {
+		t.Errorf("Reserve() before Start error = %v, want %v", err, ErrDispatcherStopped)
+	}
+
+	d.Start()
+	submit, err := d.Reserve()
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		d.Shutdown()
+	}()
+
+	// Shutdown rejects new orders, but waits for the reserved one
+	for d.Ready() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := d.Reserve(); !errors.Is(err, ErrDispatcherStopped) {
+		t.Errorf("Reserve() after Shutdown error = %v, want %v", err, ErrDispatcherStopped)
+	}
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown() returned before the reserved order was submitted")
+	default:
+	}
+	submit(context.Background(), &proto.Order{OrderId: 1})
+	<-shutdown
+}
+
+func TestDispatcherBackpressure(t *testing.T) {
+	d := NewOrderDispatcher(1, 1)
+	d.running.Store(true) // Running, but processing no order, so the queue fills up
+
+	submit, err := d.Reserve()
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	submit(context.Background(), &proto.Order{OrderId: 1})
+	if err := d.Ready(); err != nil {
+		t.Errorf("Ready() with a full queue error = %v, want nil", err)
+	}
+
+	submit, err = d.Reserve()
+	if err != nil {
+		t.Fatalf("Reserve() with a full queue error = %v", err)
+	}
+	submitted := make(chan struct{})
+	go func() {
+		defer close(submitted)
+		submit(context.Background(), &proto.Order{OrderId: 2})
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("submit() with a full queue returned, want it to wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	go d.processOrders()
+	<-submitted
+	d.Shutdown()
+	d.Shutdown() // Does not close the queue again
+}
@@ -0,0 +1,169 @@
+package orders
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// sseHeartbeatInterval is how often a comment is sent on an idle event stream, so that proxies
+	// and load balancers keep it open
+	sseHeartbeatInterval = 15 * time.Second
+	// sseResetEvent tells the client that changes it did not receive are no longer kept,
+	// and that it must retrieve the orders again
+	sseResetEvent = "reset"
+)
+
+// eventStreams streams the changes of the orders to REST clients as Server-Sent Events,
+// and over WebSockets. Each event has the position of the change in the feed as id, its kind as type
+// and the order as JSON data, so that browsers reconnecting with Last-Event-ID resume after the last event they got
+type eventStreams struct {
+	changes      *ChangeFeed
+	orderService proto.OrderServiceServer
+	auth         *Authenticator // WebSocket connections are authenticated by the streams
+	policy       *Policy
+	done         chan struct{}             // Closed when the server stops, ending the streams
+	mu           *sync.Mutex               // Orders the closing with the start of WebSocket connections
+	sockets      *sync.WaitGroup           // WebSocket connections, which the HTTP server does not wait for as they are hijacked
+	origins      *atomic.Pointer[[]string] // Host patterns of the other origins allowed to open WebSocket connections
+}
+
+// newEventStreams creates the event streams of the changes of the feed. orderService checks that
+// callers may retrieve the orders they stream the changes of
+func newEventStreams(changes *ChangeFeed, orderService proto.OrderServiceServer, auth *Authenticator, policy *Policy) eventStreams {
+	return eventStreams{
+		changes:      changes,
+		orderService: orderService,
+		auth:         auth,
+		policy:       policy,
+		done:         make(chan struct{}),
+		mu:           &sync.Mutex{},
+		sockets:      &sync.WaitGroup{},
+		origins:      &atomic.Pointer[[]string]{},
+	}
+}
+
+// close ends the active streams, and waits for the WebSocket connections to be closed
+func (e eventStreams) close() {
+	e.mu.Lock()
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+	e.mu.Unlock()
+	e.sockets.Wait()
+}
+
+// addSocket counts a WebSocket connection that close waits for. It returns false once the streams are closed
+func (e eventStreams) addSocket() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.done:
+		return false
+	default:
+		e.sockets.Add(1)
+		return true
+	}
+}
+
+// order streams the changes of the order in the path. Callers limited to their own orders
+// may only stream the changes of their orders
+func (e eventStreams) order(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		writeError(c, status.Error(codes.InvalidArgument, "invalid order id"))
+		return
+	}
+	if _, err := e.orderService.Retrieve(c.Request.Context(), &proto.RetrieveOrderRequest{OrderId: id}); err != nil {
+		writeError(c, err)
+		return
+	}
+	e.stream(c, func(change OrderChange) bool {
+		return change.Order.OrderId == id
+	})
+}
+
+// orders streams the changes of all the orders the caller may list
+func (e eventStreams) orders(c *gin.Context) {
+	ctx := c.Request.Context()
+	e.stream(c, func(change OrderChange) bool {
+		return ownsOrder(ctx, change.Order)
+	})
+}
+
+// stream sends the changes matching the filter as events, from the one after Last-Event-ID if set,
+// until the client disconnects or the server stops
+func (e eventStreams) stream(c *gin.Context, filter func(OrderChange) bool) {
+	seq := e.changes.Last()
+	if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		var err error
+		if seq, err = strconv.ParseInt(lastEventID, 10, 64); err != nil {
+			writeError(c, status.Error(codes.InvalidArgument, "invalid Last-Event-ID"))
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Keeps nginx from buffering the events
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		changes, next, ok := e.changes.Since(seq)
+		if !ok {
+			seq = e.changes.Last()
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: {}\n\n", seq, sseResetEvent); err != nil {
+				return
+			}
+			continue
+		}
+		for _, change := range changes {
+			seq = change.Seq
+			if !filter(change) {
+				continue
+			}
+			if err := writeEvent(c.Writer, change); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-next:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// writeEvent writes a change as a Server-Sent Event
+func writeEvent(w gin.ResponseWriter, change OrderChange) error {
+	data, err := protojson.Marshal(change.Order)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Kind(), data)
+	return err
+}
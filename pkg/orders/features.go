@@ -0,0 +1,20 @@
+package orders
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Features switches optional RPCs of the order service on and off
+type Features struct {
+	AsyncCreate bool // CreateAsync, and Prefer: respond-async on POST /order
+	QuoteOrder  bool // QuoteOrder and POST /order/quote
+}
+
+// DefaultFeatures enables every feature
+var DefaultFeatures = Features{AsyncCreate: true, QuoteOrder: true}
+
+// errFeatureDisabled returns the error of an RPC whose feature is switched off
+func errFeatureDisabled(rpc string) error {
+	return status.Errorf(codes.Unimplemented, "%s is disabled", rpc)
+}
@@ -0,0 +1,103 @@
+package orders
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// updatableOrderFields are the top-level Order fields an update mask may reference
+var updatableOrderFields = map[string]bool{
+	"items":          true,
+	"payment_method": true,
+}
+
+// fieldMaskOfUpdate returns the update mask of the updatable fields set in an update request,
+// whatever the encoding of its body. An empty repeated field cannot be told from a missing one,
+// so PATCH cannot clear the items, which an order must have anyway
+func fieldMaskOfUpdate(req *proto.UpdateOrderRequest) *fieldmaskpb.FieldMask {
+	mask := &fieldmaskpb.FieldMask{}
+	if len(req.Items) > 0 {
+		mask.Paths = append(mask.Paths, "items")
+	}
+	if req.PaymentMethod != nil {
+		mask.Paths = append(mask.Paths, "payment_method")
+	}
+	return mask
+}
+
+// updatesPayment tells whether an update request changes the payment method: without a mask,
+// or with payment_method or one of its subfields in the mask
+func updatesPayment(req *proto.UpdateOrderRequest) bool {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(paths, func(path string) bool {
+		top, _, _ := strings.Cut(path, ".")
+		return top == "payment_method"
+	})
+}
+
+// mergeUpdate returns the order resulting from applying the update request to current.
+// Fields in the update mask are copied from the request; without a mask, items and
+// payment_method are replaced as a whole
+func mergeUpdate(current *proto.Order, req *proto.UpdateOrderRequest) (*proto.Order, error) {
+	merged := &proto.Order{Items: current.Items, PaymentMethod: current.PaymentMethod}
+	patch := &proto.Order{Items: req.Items, PaymentMethod: req.PaymentMethod}
+
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return patch, nil
+	}
+
+	mask := &fieldmaskpb.FieldMask{Paths: paths}
+	if !mask.IsValid(merged) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid update mask %v", paths)
+	}
+	mask.Normalize()
+
+	for _, path := range mask.Paths {
+		if top, _, _ := strings.Cut(path, "."); !updatableOrderFields[top] {
+			return nil, status.Errorf(codes.InvalidArgument, "field %q cannot be updated", path)
+		}
+		if err := copyPath(merged.ProtoReflect(), patch.ProtoReflect(), strings.Split(path, ".")); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// copyPath copies the field at path from src to dst. Intermediate message
+// fields are created in dst as needed; an unset field in src clears it in dst
+func copyPath(dst, src protoreflect.Message, path []string) error {
+	fd := dst.Descriptor().Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil {
+		return status.Errorf(codes.InvalidArgument, "unknown field %q", path[0])
+	}
+
+	if len(path) == 1 {
+		if src.Has(fd) {
+			dst.Set(fd, src.Get(fd))
+		} else {
+			dst.Clear(fd)
+		}
+		return nil
+	}
+
+	if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+		return status.Errorf(codes.InvalidArgument, "field %q has no subfields", path[0])
+	}
+	var srcChild protoreflect.Message
+	if src.Has(fd) {
+		srcChild = src.Get(fd).Message()
+	} else {
+		srcChild = src.Get(fd).Message().New()
+	}
+	return copyPath(dst.Mutable(fd).Message(), srcChild, path[1:])
+}
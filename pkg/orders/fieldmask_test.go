@@ -0,0 +1,89 @@
+package orders
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestFieldMaskOfUpdate(t *testing.T) {
+	items := []*proto.Item{{Description: "a", Price: 1}}
+	visa := &proto.PaymentMethod{PaymentType: proto.PaymentMethod_VISA}
+	tests := []struct {
+		name string
+		req  *proto.UpdateOrderRequest
+		want []string
+	}{
+		{name: "items", req: &proto.UpdateOrderRequest{Items: items}, want: []string{"items"}},
+		{name: "payment method", req: &proto.UpdateOrderRequest{PaymentMethod: visa}, want: []string{"payment_method"}},
+		{name: "both", req: &proto.UpdateOrderRequest{Items: items, PaymentMethod: visa}, want: []string{"items", "payment_method"}},
+		{name: "empty payment method", req: &proto.UpdateOrderRequest{PaymentMethod: &proto.PaymentMethod{}}, want: []string{"payment_method"}},
+		{name: "version only", req: &proto.UpdateOrderRequest{OrderId: 1, ExpectedVersion: 3}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldMaskOfUpdate(tt.req).Paths; !slices.Equal(got, tt.want) {
+				t.Errorf("fieldMaskOfUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatesPayment(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  bool
+	}{
+		{nil, true},
+		{[]string{"items"}, false},
+		{[]string{"payment_method"}, true},
+		{[]string{"items", "payment_method.payment_type"}, true},
+	}
+	for _, tt := range tests {
+		req := &proto.UpdateOrderRequest{UpdateMask: &fieldmaskpb.FieldMask{Paths: tt.paths}}
+		if got := updatesPayment(req); got != tt.want {
+			t.Errorf("updatesPayment(%v) = %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
+
+// TestUpdateKeptPaymentMethod checks that an update keeping the stored payment method, whose
+// pre-authorization token is not stored, does not authorize the payment again
+func TestUpdateKeptPaymentMethod(t *testing.T) {
+	// The inventory check takes 2s and the payment authorization 3s
+	const timeout = 2500 * time.Millisecond
+	items := []*proto.Item{{Description: "b", Price: 2}}
+
+	tests := []struct {
+		name string
+		req  *proto.UpdateOrderRequest
+		code codes.Code
+	}{
+		{name: "items only", req: &proto.UpdateOrderRequest{Items: items, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"items"}}}, code: codes.OK},
+		{name: "whole order", req: &proto.UpdateOrderRequest{Items: items, PaymentMethod: &proto.PaymentMethod{PaymentType: proto.PaymentMethod_VISA}}, code: codes.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			store := NewOrderStore()
+			s := NewOrderService(store, NewOperations(time.Hour), NewOrderDispatcher(1, 1), Pricing{}, timeout, Features{})
+			order := store.Create(&proto.Order{
+				Items:         []*proto.Item{{Description: "a", Price: 1}},
+				PaymentMethod: storedPaymentMethod(&proto.PaymentMethod{PaymentType: proto.PaymentMethod_VISA, PreAuthorizationToken: "secret"}),
+			})
+
+			tt.req.OrderId, tt.req.ExpectedVersion = order.OrderId, order.Version
+			_, err := s.Update(withGrant(context.Background(), grant{all: true, allStatuses: true}), tt.req)
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("Update() error = %v, want code %v", err, tt.code)
+			}
+		})
+	}
+}
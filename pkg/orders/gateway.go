@@ -0,0 +1,565 @@
+package orders
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// gatewayRouteKey is the gin context key of the path template of the route a request matched
+const gatewayRouteKey = "gateway.route"
+
+// Gateway serves gRPC services as a REST API, gateway-style: the routes come from the google.api.http
+// rules of the RPCs, path variables and query parameters are bound to request fields, the body is
+// decoded into the request (or one of its fields) and the response is encoded, in JSON, binary protobuf
+// or YAML as negotiated with the Content-Type and Accept headers.
+// Services are registered like on a gRPC server, with their generated Register function.
+// Calls go through the interceptor, and through the hooks of their RPC
+type Gateway struct {
+	routes      []*gatewayRoute
+	handlers    map[string]gatewayHandler // By full RPC name
+	interceptor grpc.UnaryServerInterceptor
+	hooks       map[string]gatewayHook // By full RPC name
+	extraRules  map[string][]*annotations.HttpRule
+	decoding    atomic.Pointer[BodyDecoding] // Can change while the gateway serves
+}
+
+// BodyDecoding configures how request bodies are decoded
+type BodyDecoding struct {
+	// MaxSize is the size in bytes above which bodies are rejected with 413 Content Too Large
+	MaxSize int64
+	// DiscardUnknown ignores the fields the server does not know instead of rejecting the body,
+	// for clients built against a newer API
+	DiscardUnknown bool
+}
+
+// DefaultBodyDecoding accepts bodies of up to 1 MiB, without unknown fields
+var DefaultBodyDecoding = BodyDecoding{MaxSize: 1 << 20}
+
+// gatewayRoute is a REST route of an RPC, from a google.api.http rule
+type gatewayRoute struct {
+	httpMethod string
+	template   string
+	path       pathTemplate
+	body       string // Request field the body is decoded into, "*" for the whole request, empty for no body
+	fullMethod string
+	input      protoreflect.MessageType
+	output     protoreflect.MessageDescriptor
+}
+
+// gatewayHandler calls an RPC through the interceptor, with dec filling the request
+type gatewayHandler func(ctx context.Context, dec func(any) error) (any, error)
+
+// gatewayHook adapts the routes of an RPC to REST conventions the google.api.http rules do not express
+type gatewayHook struct {
+	// request is called with the decoded request, and the body in JSON, before the call.
+	// It returns false if it responded
+	request func(c *gin.Context, req protobuf.Message, body []byte) bool
+	// response is called with the response of a successful call and returns the HTTP status
+	response func(c *gin.Context, resp protobuf.Message) int
+	// queryAliases are other names of query parameters, by alias, such as the ones of the first REST API
+	queryAliases map[string]string
+
+	// For the OpenAPI document: the request headers the hook reads, the HTTP status of successful calls
+	// if not 200 OK, and the other statuses the hook responds with, with their descriptions
+	headers  map[string]string
+	status   int
+	statuses map[int]string
+}
+
+// NewGateway creates a Gateway calling the services through the interceptor. extraRules adds
+// HTTP rules to RPCs by full name, for services whose proto files cannot be annotated
+func NewGateway(interceptor grpc.UnaryServerInterceptor, extraRules map[string][]*annotations.HttpRule) *Gateway {
+	g := &Gateway{
+		handlers:    make(map[string]gatewayHandler),
+		interceptor: interceptor,
+		hooks:       make(map[string]gatewayHook),
+		extraRules:  extraRules,
+	}
+	g.SetBodyDecoding(DefaultBodyDecoding)
+	return g
+}
+
+// SetBodyDecoding changes how request bodies are decoded
+func (g *Gateway) SetBodyDecoding(decoding BodyDecoding) {
+	g.decoding.Store(&decoding)
+}
+
+// RegisterService adds the routes of the unary RPCs of a service, implementing grpc.ServiceRegistrar.
+// RPCs without HTTP rules get no route, but hooks can call them. It panics on an invalid rule
+func (g *Gateway) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(desc.ServiceName))
+	if err != nil {
+		panic(fmt.Sprintf("gateway: service %s: %v", desc.ServiceName, err))
+	}
+	methods := d.(protoreflect.ServiceDescriptor).Methods()
+
+	for _, m := range desc.Methods {
+		md := methods.ByName(protoreflect.Name(m.MethodName))
+		input, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+		if err != nil {
+			panic(fmt.Sprintf("gateway: %s: %v", md.FullName(), err))
+		}
+		fullMethod := "/" + desc.ServiceName + "/" + m.MethodName
+		handler := m.Handler
+		g.handlers[fullMethod] = func(ctx context.Context, dec func(any) error) (any, error) {
+			return handler(impl, ctx, dec, g.interceptor)
+		}
+
+		var rules []*annotations.HttpRule
+		if rule, ok := protobuf.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule); ok && rule != nil {
+			rules = append(append(rules, rule), rule.AdditionalBindings...)
+		}
+		for _, rule := range append(rules, g.extraRules[fullMethod]...) {
+			route, err := newGatewayRoute(rule, md.Input())
+			if err != nil {
+				panic(fmt.Sprintf("gateway: %s: %v", md.FullName(), err))
+			}
+			route.fullMethod, route.input, route.output = fullMethod, input, md.Output()
+			g.routes = append(g.routes, route)
+		}
+	}
+}
+
+// hook sets the hook of an RPC
+func (g *Gateway) hook(fullMethod string, h gatewayHook) {
+	g.hooks[fullMethod] = h
+}
+
+// newGatewayRoute creates the route of an HTTP rule of an RPC with the given input
+func newGatewayRoute(rule *annotations.HttpRule, input protoreflect.MessageDescriptor) (*gatewayRoute, error) {
+	route := &gatewayRoute{body: rule.Body}
+	switch p := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		route.httpMethod, route.template = http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		route.httpMethod, route.template = http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		route.httpMethod, route.template = http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		route.httpMethod, route.template = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		route.httpMethod, route.template = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		route.httpMethod, route.template = p.Custom.Kind, p.Custom.Path
+	default:
+		return nil, fmt.Errorf("rule without pattern")
+	}
+
+	var err error
+	if route.path, err = parsePathTemplate(route.template); err != nil {
+		return nil, fmt.Errorf("%s: %w", route.template, err)
+	}
+	for _, v := range route.path.vars {
+		if _, err := fieldByPath(input, v.field); err != nil {
+			return nil, fmt.Errorf("%s: %w", route.template, err)
+		}
+	}
+	if route.body != "" && route.body != "*" {
+		fd, err := fieldByPath(input, route.body)
+		if err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+		if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("body: %s is not a message field", route.body)
+		}
+	}
+	return route, nil
+}
+
+// serve handles the requests no gin route matched, with the route of the gateway matching the path
+// and the method. It responds with 404 Not Found if no route matches the path, and with
+// 405 Method Not Allowed if the routes matching the path are for other methods
+func (g *Gateway) serve(c *gin.Context) {
+	var allowed []string
+	for _, route := range g.routes {
+		vars, ok := route.path.match(c.Request.URL.EscapedPath())
+		if !ok {
+			continue
+		}
+		if route.httpMethod != c.Request.Method {
+			if !slices.Contains(allowed, route.httpMethod) {
+				allowed = append(allowed, route.httpMethod)
+			}
+			continue
+		}
+		c.Set(gatewayRouteKey, route.template)
+		g.serveRoute(c, route, vars)
+		return
+	}
+
+	if len(allowed) > 0 {
+		c.Header("Allow", strings.Join(allowed, ", "))
+		writeStatus(c, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "method not allowed"))
+		return
+	}
+	writeStatus(c, http.StatusNotFound, status.New(codes.NotFound, "not found"))
+}
+
+// serveRoute builds the request of the route from the path variables, the query parameters and the body,
+// and calls the RPC. It responds with 406 Not Acceptable if no codec matches the Accept header,
+// with 415 Unsupported Media Type if none matches the Content-Type of the body and with
+// 413 Content Too Large if the body is larger than the maximum size
+func (g *Gateway) serveRoute(c *gin.Context, route *gatewayRoute, vars map[string]string) {
+	c.Header("Vary", "Accept")
+	codec, ok := responseCodec(c.GetHeader("Accept"))
+	if !ok {
+		writeStatus(c, http.StatusNotAcceptable, status.Newf(codes.InvalidArgument, "none of the accepted media types is supported: use one of %s", mediaTypes()))
+		return
+	}
+	c.Set(restCodecKey, codec)
+
+	req := route.input.New()
+
+	var body []byte
+	if route.body != "" {
+		decoding := g.decoding.Load()
+		var err error
+		if body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, decoding.MaxSize)); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeStatus(c, http.StatusRequestEntityTooLarge, status.Newf(codes.ResourceExhausted, "request body larger than %d bytes", tooLarge.Limit))
+				return
+			}
+			writeError(c, status.Error(codes.InvalidArgument, "error reading request body"))
+			return
+		}
+		target := req
+		if route.body != "*" {
+			fd, _ := fieldByPath(req.Descriptor(), route.body)
+			target = mutableParent(req, route.body).Mutable(fd).Message()
+		}
+		if len(body) > 0 {
+			bodyCodec, ok := requestCodec(c.GetHeader("Content-Type"))
+			if !ok {
+				writeStatus(c, http.StatusUnsupportedMediaType, status.Newf(codes.InvalidArgument, "unsupported content type %q: use one of %s", c.GetHeader("Content-Type"), mediaTypes()))
+				return
+			}
+			if err := bodyCodec.unmarshal(body, target.Interface(), decoding.DiscardUnknown); err != nil {
+				writeError(c, bodyError(err))
+				return
+			}
+			// Hooks read the fields present in the body from its JSON form
+			if bodyCodec != jsonCodec {
+				if body, err = protojson.Marshal(target.Interface()); err != nil {
+					writeError(c, status.Errorf(codes.InvalidArgument, "invalid request body: %v", err))
+					return
+				}
+			}
+		}
+	}
+
+	for field, value := range vars {
+		if err := setField(req, field, value); err != nil {
+			writeError(c, status.Errorf(codes.InvalidArgument, "path: %v", err))
+			return
+		}
+	}
+	// With a body for the whole request, every field can be set in the body and query parameters are ignored
+	hook := g.hooks[route.fullMethod]
+	if route.body != "*" {
+		for name, values := range c.Request.URL.Query() {
+			if field, ok := hook.queryAliases[name]; ok {
+				name = field
+			}
+			inBody := route.body != "" && (name == route.body || strings.HasPrefix(name, route.body+"."))
+			if _, inPath := vars[name]; inPath || inBody {
+				writeError(c, status.Errorf(codes.InvalidArgument, "query parameter %q: field set by the path or the body", name))
+				return
+			}
+			for _, value := range values {
+				if err := setField(req, name, value); err != nil {
+					writeError(c, status.Errorf(codes.InvalidArgument, "query parameter %q: %v", name, err))
+					return
+				}
+			}
+		}
+	}
+
+	if hook.request != nil && !hook.request(c, req.Interface(), body) {
+		return
+	}
+	g.call(c, route.fullMethod, req.Interface())
+}
+
+// call calls an RPC with a request and writes its response, through the hooks of the RPC
+func (g *Gateway) call(c *gin.Context, fullMethod string, req protobuf.Message) {
+	handler, ok := g.handlers[fullMethod]
+	if !ok {
+		writeError(c, status.Errorf(codes.Unimplemented, "unknown method %s", fullMethod))
+		return
+	}
+
+	resp, err := handler(c.Request.Context(), func(in any) error {
+		protobuf.Merge(in.(protobuf.Message), req)
+		return nil
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	msg := resp.(protobuf.Message)
+	code := http.StatusOK
+	if h, ok := g.hooks[fullMethod]; ok && h.response != nil {
+		code = h.response(c, msg)
+	}
+	if _, empty := msg.(*emptypb.Empty); empty && code == http.StatusOK {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if code == http.StatusNotModified {
+		c.Status(code)
+		return
+	}
+	writeProto(c, code, msg)
+}
+
+// routeOf returns the route of a request for logs and metrics: the gin route, or the path template
+// of the gateway route. It is empty for requests no route matched
+func routeOf(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return c.GetString(gatewayRouteKey)
+}
+
+// pathTemplate is a parsed google.api.http path template, such as /v1/{name=operations/**}:cancel
+type pathTemplate struct {
+	segments []string // Literals, "*" or "**" (last only)
+	vars     []pathVariable
+	verb     string
+}
+
+// pathVariable binds the path segments from start to end (excluded) to a field
+type pathVariable struct {
+	field      string
+	start, end int
+}
+
+// parsePathTemplate parses a path template
+func parsePathTemplate(template string) (pathTemplate, error) {
+	var t pathTemplate
+	if !strings.HasPrefix(template, "/") {
+		return t, fmt.Errorf("path must start with /")
+	}
+	rest := template[1:]
+
+	// The verb follows the last colon outside of a variable
+	depth := 0
+	for i, r := range rest {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				rest, t.verb = rest[:i], rest[i+1:]
+			}
+		}
+		if t.verb != "" {
+			break
+		}
+	}
+
+	for rest != "" {
+		if strings.HasPrefix(rest, "{") {
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return t, fmt.Errorf("unterminated variable")
+			}
+			field, pattern, ok := strings.Cut(rest[1:end], "=")
+			if !ok {
+				pattern = "*"
+			}
+			v := pathVariable{field: field, start: len(t.segments)}
+			t.segments = append(t.segments, strings.Split(pattern, "/")...)
+			v.end = len(t.segments)
+			t.vars = append(t.vars, v)
+			rest = strings.TrimPrefix(rest[end+1:], "/")
+			continue
+		}
+		segment, next, _ := strings.Cut(rest, "/")
+		t.segments = append(t.segments, segment)
+		rest = next
+	}
+
+	for i, s := range t.segments {
+		if s == "" || strings.ContainsAny(s, "{}") {
+			return t, fmt.Errorf("invalid segment %q", s)
+		}
+		if s == "**" && i != len(t.segments)-1 {
+			return t, fmt.Errorf("** must be the last segment")
+		}
+	}
+	return t, nil
+}
+
+// match matches an escaped URL path against the template, returning the values of the variables
+func (t pathTemplate) match(path string) (map[string]string, bool) {
+	if t.verb != "" {
+		var ok bool
+		if path, ok = strings.CutSuffix(path, ":"+t.verb); !ok {
+			return nil, false
+		}
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	deep := len(t.segments) > 0 && t.segments[len(t.segments)-1] == "**"
+	if len(parts) != len(t.segments) && !(deep && len(parts) >= len(t.segments)) {
+		return nil, false
+	}
+	for i, s := range t.segments {
+		if s != "*" && s != "**" && s != parts[i] {
+			return nil, false
+		}
+		if (s == "*" || s == "**") && parts[i] == "" {
+			return nil, false
+		}
+	}
+
+	vars := make(map[string]string, len(t.vars))
+	for _, v := range t.vars {
+		end := v.end
+		if deep && end == len(t.segments) {
+			end = len(parts)
+		}
+		value, err := url.PathUnescape(strings.Join(parts[v.start:end], "/"))
+		if err != nil {
+			return nil, false
+		}
+		vars[v.field] = value
+	}
+	return vars, true
+}
+
+// fieldByPath returns the field of a message at a dotted path of field names, such as payment_method.payment_type
+func fieldByPath(md protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	var fd protoreflect.FieldDescriptor
+	for i, name := range strings.Split(path, ".") {
+		if i > 0 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return nil, fmt.Errorf("unknown field %q", path)
+			}
+			md = fd.Message()
+		}
+		if fd = md.Fields().ByName(protoreflect.Name(name)); fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("unknown field %q", path)
+		}
+	}
+	return fd, nil
+}
+
+// mutableParent returns the message holding the field at a dotted path, creating the intermediate messages
+func mutableParent(msg protoreflect.Message, path string) protoreflect.Message {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		fd, _ := fieldByPath(msg.Descriptor(), name)
+		msg = msg.Mutable(fd).Message()
+	}
+	return msg
+}
+
+// setField sets the field at a dotted path of msg from its string form in a path or a query parameter.
+// Repeated fields get the value appended
+func setField(msg protoreflect.Message, path, value string) error {
+	fd, err := fieldByPath(msg.Descriptor(), path)
+	if err != nil {
+		return err
+	}
+	if fd.IsMap() {
+		return fmt.Errorf("map field %q cannot be set from a string", path)
+	}
+	parent := mutableParent(msg, path)
+
+	var v protoreflect.Value
+	if fd.Kind() == protoreflect.MessageKind {
+		// Well-known types such as Duration, Timestamp and FieldMask have a JSON string form
+		var m protoreflect.Message
+		if fd.IsList() {
+			m = parent.Mutable(fd).List().NewElement().Message()
+		} else {
+			m = parent.NewField(fd).Message()
+		}
+		quoted, _ := json.Marshal(value)
+		if err := protojson.Unmarshal(quoted, m.Interface()); err != nil {
+			return fmt.Errorf("invalid value for %q: %v", path, err)
+		}
+		v = protoreflect.ValueOfMessage(m)
+	} else if v, err = parseScalar(fd, value); err != nil {
+		return fmt.Errorf("invalid value %q for %q", value, path)
+	}
+
+	if fd.IsList() {
+		parent.Mutable(fd).List().Append(v)
+	} else {
+		parent.Set(fd, v)
+	}
+	return nil
+}
+
+// parseScalar parses the string form of a value of a scalar or enum field
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		b, err := base64.URLEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.StdEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
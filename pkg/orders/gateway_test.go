@@ -0,0 +1,119 @@
+package orders
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		segments []string
+		vars     []pathVariable
+		verb     string
+		wantErr  bool
+	}{
+		{template: "/v1/orders", segments: []string{"v1", "orders"}},
+		{template: "/v1/orders/{order_id}", segments: []string{"v1", "orders", "*"}, vars: []pathVariable{{field: "order_id", start: 2, end: 3}}},
+		{template: "/v1/orders:quote", segments: []string{"v1", "orders"}, verb: "quote"},
+		{template: "/v1/orders/{order_id}:updateStatus", segments: []string{"v1", "orders", "*"}, vars: []pathVariable{{field: "order_id", start: 2, end: 3}}, verb: "updateStatus"},
+		{template: "/v1/{name=operations/*}", segments: []string{"v1", "operations", "*"}, vars: []pathVariable{{field: "name", start: 1, end: 3}}},
+		{template: "/v1/{name=operations/**}:cancel", segments: []string{"v1", "operations", "**"}, vars: []pathVariable{{field: "name", start: 1, end: 3}}, verb: "cancel"},
+		{template: "v1/orders", wantErr: true},
+		{template: "/v1/{order_id", wantErr: true},
+		{template: "/v1//orders", wantErr: true},
+		{template: "/v1/**/orders", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := parsePathTemplate(tt.template)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsePathTemplate() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePathTemplate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.segments, tt.segments) || !reflect.DeepEqual(got.vars, tt.vars) || got.verb != tt.verb {
+				t.Errorf("parsePathTemplate() = %+v, want segments %v, vars %v, verb %q", got, tt.segments, tt.vars, tt.verb)
+			}
+		})
+	}
+}
+
+func TestPathTemplateMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		vars     map[string]string // nil if the path does not match
+	}{
+		{"/v1/orders", "/v1/orders", map[string]string{}},
+		{"/v1/orders", "/v1/orders/1", nil},
+		{"/v1/orders/{order_id}", "/v1/orders/42", map[string]string{"order_id": "42"}},
+		{"/v1/orders/{order_id}", "/v1/orders/", nil},
+		{"/v1/orders/{order_id}", "/v1/orders", nil},
+		{"/v1/orders/{order_id}:updateStatus", "/v1/orders/42:updateStatus", map[string]string{"order_id": "42"}},
+		{"/v1/orders/{order_id}:updateStatus", "/v1/orders/42", nil},
+		{"/v1/orders/{order_id}", "/v1/orders/42:updateStatus", map[string]string{"order_id": "42:updateStatus"}},
+		{"/v1/orders:quote", "/v1/orders:quote", map[string]string{}},
+		{"/v1/orders:quote", "/v1/orders:createAsync", nil},
+		{"/v1/{name=operations/*}", "/v1/operations/abc", map[string]string{"name": "operations/abc"}},
+		{"/v1/{name=operations/*}", "/v1/orders/abc", nil},
+		{"/v1/{name=operations/**}", "/v1/operations/a/b", map[string]string{"name": "operations/a/b"}},
+		{"/v1/{name=operations/*}:cancel", "/v1/operations/abc:cancel", map[string]string{"name": "operations/abc"}},
+		{"/v1/orders/{order_id}", "/v1/orders/a%2Fb", map[string]string{"order_id": "a/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template+" "+tt.path, func(t *testing.T) {
+			template, err := parsePathTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("parsePathTemplate() error = %v", err)
+			}
+			vars, ok := template.match(tt.path)
+			if ok != (tt.vars != nil) || (ok && !reflect.DeepEqual(vars, tt.vars)) {
+				t.Errorf("match() = %v, %v, want %v", vars, ok, tt.vars)
+			}
+		})
+	}
+}
+
+func TestSetField(t *testing.T) {
+	tests := []struct {
+		path, value string
+		want        *proto.UpdateOrderRequest
+		wantErr     bool
+	}{
+		{path: "order_id", value: "7", want: &proto.UpdateOrderRequest{OrderId: 7}},
+		{path: "orderId", value: "7", want: &proto.UpdateOrderRequest{OrderId: 7}},
+		{path: "payment_method.payment_type", value: "VISA", want: &proto.UpdateOrderRequest{PaymentMethod: &proto.PaymentMethod{PaymentType: proto.PaymentMethod_VISA}}},
+		{path: "payment_method.payment_type", value: "2", want: &proto.UpdateOrderRequest{PaymentMethod: &proto.PaymentMethod{PaymentType: proto.PaymentMethod_MASTERCARD}}},
+		{path: "update_mask", value: "items,paymentMethod", want: &proto.UpdateOrderRequest{UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"items", "payment_method"}}}},
+		{path: "order_id", value: "seven", wantErr: true},
+		{path: "unknown", value: "1", wantErr: true},
+		{path: "order_id.value", value: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+"="+tt.value, func(t *testing.T) {
+			req := &proto.UpdateOrderRequest{}
+			err := setField(req.ProtoReflect(), tt.path, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("setField() = %v, want an error", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setField() error = %v", err)
+			}
+			if !protobuf.Equal(req, tt.want) {
+				t.Errorf("setField() = %v, want %v", req, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package orders
 
 import (
+	"crypto/tls"
 	"net"
 
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
 	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // GrpcServer implements a gRPC server for the order service
@@ -14,20 +19,37 @@ type GrpcServer struct {
 	listener net.Listener
 }
 
-// NewGrpcServer function is excellent for creating a GrpcServer
-func NewGrpcServer(service proto.OrderServiceServer, port string) (GrpcServer, error) {
-	lis, err := net.Listen("tcp", ":"+port)
-	if err != nil {
-		return GrpcServer{}, err
+// NewGrpcServer function is excellent for creating a GrpcServer.
+// The operations service serves the operations started by the order service,
+// every call must carry a bearer token accepted by auth and be allowed by the policy,
+// except health checks, which are answered by health.
+// The server uses TLS if tlsConfig is not nil, and serves on lis
+func NewGrpcServer(service proto.OrderServiceServer, operations longrunningpb.OperationsServer, health *Health, auth *Authenticator, policy *Policy, tlsConfig *tls.Config, lis net.Listener) GrpcServer {
+	opts := []grpc.ServerOption{
+		// Spans are started from the W3C trace context of the incoming metadata
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unaryInterceptors(auth, policy)...),
+		grpc.ChainStreamInterceptor(loggingStreamInterceptor, metricsStreamInterceptor, auth.StreamInterceptor, policy.StreamInterceptor),
 	}
-	server := grpc.NewServer()
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
 	proto.RegisterOrderServiceServer(server, service)
+	longrunningpb.RegisterOperationsServer(server, operations)
+	healthpb.RegisterHealthServer(server, health.server)
 
 	return GrpcServer{
 		server:   server,
 		listener: lis,
 		errCh:    make(chan error),
-	}, nil
+	}
+}
+
+// unaryInterceptors returns the interceptors of unary calls, in the order they run.
+// The Connect handler runs its calls through them as well
+func unaryInterceptors(auth *Authenticator, policy *Policy) []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{loggingUnaryInterceptor, metricsUnaryInterceptor, auth.UnaryInterceptor, policy.UnaryInterceptor, idempotencyKeyInterceptor}
 }
 
 // Start launches the gRPC server in the background, sending errors to the error channel
@@ -0,0 +1,130 @@
+package orders
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// healthCheckInterval is how often the gRPC serving status is refreshed from the readiness checks
+	healthCheckInterval = 5 * time.Second
+	// healthCheckTimeout bounds each round of readiness checks
+	healthCheckTimeout = 2 * time.Second
+	// operationsServiceName is the name of the google.longrunning.Operations service
+	operationsServiceName = "google.longrunning.Operations"
+)
+
+// publicMethods are the RPCs callable without credentials, so that probes need no token
+var publicMethods = map[string]bool{
+	healthpb.Health_Check_FullMethodName: true,
+	healthpb.Health_Watch_FullMethodName: true,
+}
+
+// healthCheck is a named readiness check of a dependency of the order service
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// Health reports the liveness and readiness of the order service, over grpc.health.v1
+// and the REST /healthz and /readyz endpoints. The service is ready when the order store,
+// the payment and inventory services and the dispatcher are, until Shutdown
+type Health struct {
+	server       *health.Server
+	checks       []healthCheck
+	shuttingDown atomic.Bool
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// NewHealth creates a Health checking the store and the dispatcher
+func NewHealth(store *OrderStore, dispatcher OrderDispatcher) *Health {
+	return &Health{
+		server: health.NewServer(),
+		checks: []healthCheck{
+			{name: "store", check: store.Ping},
+			{name: "payment", check: pingPaymentService},
+			{name: "inventory", check: pingInventoryService},
+			{name: "dispatcher", check: func(context.Context) error { return dispatcher.Ready() }},
+		},
+		stop: make(chan struct{}),
+	}
+}
+
+// Check runs the readiness checks and returns the error of each failed one, by check name
+func (h *Health) Check(ctx context.Context) map[string]string {
+	failed := make(map[string]string)
+	if h.shuttingDown.Load() {
+		failed["shutdown"] = "the service is shutting down"
+		return failed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	for _, c := range h.checks {
+		if err := c.check(ctx); err != nil {
+			failed[c.name] = err.Error()
+		}
+	}
+	return failed
+}
+
+// update sets the gRPC serving status of the server and its services from the readiness checks
+func (h *Health) update() {
+	status := healthpb.HealthCheckResponse_SERVING
+	if failed := h.Check(context.Background()); len(failed) > 0 {
+		slog.Warn("order service is not ready", "failed_checks", failed)
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	for _, service := range []string{"", proto.OrderService_ServiceDesc.ServiceName, operationsServiceName} {
+		h.server.SetServingStatus(service, status)
+	}
+}
+
+// Start refreshes the gRPC serving status in the background until Shutdown
+func (h *Health) Start() {
+	h.update()
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.update()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown makes the service NOT_SERVING for good, so load balancers stop sending it traffic
+func (h *Health) Shutdown() {
+	h.shuttingDown.Store(true)
+	h.stopOnce.Do(func() { close(h.stop) })
+	h.server.Shutdown()
+}
+
+// liveness is the handler function of /healthz. The process is alive as long as it answers
+func (h *Health) liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readiness is the handler function of /readyz, answering 503 Service Unavailable
+// with the failed checks when the service is not ready
+func (h *Health) readiness(c *gin.Context) {
+	if failed := h.Check(c.Request.Context()); len(failed) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "failed_checks": failed})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
@@ -0,0 +1,222 @@
+package orders
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const (
+	// IdempotencyKeyHeader is the HTTP header carrying the idempotency key
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotencyKeyMetadata is the gRPC metadata key carrying the idempotency key
+	IdempotencyKeyMetadata = "idempotency-key"
+)
+
+var (
+	ErrIdempotencyKeyReused     = status.Error(codes.AlreadyExists, "idempotency key was already used with a different request")
+	ErrIdempotencyKeyInProgress = status.Error(codes.Aborted, "a request with this idempotency key is still in progress")
+)
+
+type idempotencyKeyCtx struct{}
+
+// withIdempotencyKey returns a copy of ctx carrying the idempotency key
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// idempotencyKeyFromContext returns the idempotency key of the request, if any
+func idempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	return key
+}
+
+// idempotencyKeyInterceptor copies the idempotency key from the incoming gRPC metadata to the context
+func idempotencyKeyInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(IdempotencyKeyMetadata); len(keys) > 0 {
+			ctx = withIdempotencyKey(ctx, keys[0])
+		}
+	}
+	return handler(ctx, req)
+}
+
+// idempotencyKeyMiddleware copies the Idempotency-Key header to the request context
+func idempotencyKeyMiddleware(c *gin.Context) {
+	if key := c.GetHeader(IdempotencyKeyHeader); key != "" {
+		c.Request = c.Request.WithContext(withIdempotencyKey(c.Request.Context(), key))
+	}
+	c.Next()
+}
+
+// idempotencyEntry is a request seen under an idempotency key
+type idempotencyEntry struct {
+	key         string
+	requestHash [sha256.Size]byte
+	response    protobuf.Message // nil while the request is in progress
+	expires     time.Time        // A request still in progress then is presumed lost
+	elem        *list.Element    // Element of the entry in pending or done
+}
+
+// IdempotencyStore keeps the responses of requests made with an idempotency key
+// for the retention window, so a retried request returns the original result
+type IdempotencyStore struct {
+	mu             sync.Mutex
+	entries        map[string]*idempotencyEntry
+	pending        *list.List // Entries in progress, by expiry
+	done           *list.List // Entries with a response, by expiry
+	retention      time.Duration
+	pendingTimeout time.Duration
+}
+
+// NewIdempotencyStore creates an IdempotencyStore keeping responses for the retention window.
+// A request still in progress after pendingTimeout is presumed lost, and its key can be used again
+func NewIdempotencyStore(retention, pendingTimeout time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		entries:        make(map[string]*idempotencyEntry),
+		pending:        list.New(),
+		done:           list.New(),
+		retention:      retention,
+		pendingTimeout: pendingTimeout,
+	}
+}
+
+// Do executes call once per idempotency key in ctx. A replay with the same request
+// returns the saved response; a replay with a different request returns ErrIdempotencyKeyReused.
+// Keys are scoped to the caller and the method, so that a caller never gets the response of another one.
+// Failed calls are not saved, so the client can retry them with the same key
+func (s *IdempotencyStore) Do(ctx context.Context, method string, req protobuf.Message, call func() (protobuf.Message, error)) (protobuf.Message, error) {
+	key := idempotencyKeyFromContext(ctx)
+	if key == "" {
+		return call()
+	}
+	id, _ := IdentityFromContext(ctx)
+	key = id.Subject + "\x00" + method + "\x00" + key
+
+	hash, err := requestHash(method, req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.purgeExpired()
+	if entry, ok := s.entries[key]; ok {
+		s.mu.Unlock()
+		switch {
+		case entry.requestHash != hash:
+			return nil, ErrIdempotencyKeyReused
+		case entry.response == nil:
+			return nil, ErrIdempotencyKeyInProgress
+		default:
+			return protobuf.Clone(entry.response), nil
+		}
+	}
+	entry := &idempotencyEntry{key: key, requestHash: hash, expires: time.Now().Add(s.pendingTimeout)}
+	entry.elem = s.pending.PushBack(entry)
+	s.entries[key] = entry
+	s.mu.Unlock()
+
+	resp, err := call()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[key] != entry { // It expired, and the key may have been used again
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	s.pending.Remove(entry.elem)
+	if err != nil {
+		delete(s.entries, key)
+		return nil, err
+	}
+	entry.response = protobuf.Clone(resp)
+	entry.expires = time.Now().Add(s.retention)
+	entry.elem = s.done.PushBack(entry)
+	return resp, nil
+}
+
+// purgeExpired removes the entries past their expiry. Both lists are ordered by expiry,
+// since the entries of each one are added with the same timeout. The caller must hold s.mu
+func (s *IdempotencyStore) purgeExpired() {
+	now := time.Now()
+	for _, l := range []*list.List{s.pending, s.done} {
+		for front := l.Front(); front != nil; front = l.Front() {
+			entry := front.Value.(*idempotencyEntry)
+			if !now.After(entry.expires) {
+				break
+			}
+			l.Remove(front)
+			delete(s.entries, entry.key)
+		}
+	}
+}
+
+// requestHash hashes the method name and the deterministic encoding of the request
+func requestHash(method string, req protobuf.Message) ([sha256.Size]byte, error) {
+	data, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(append([]byte(method+"\x00"), data...)), nil
+}
+
+// idempotentService decorates an order service so that Create, CreateAsync and Update
+// are executed at most once per idempotency key
+type idempotentService struct {
+	proto.OrderServiceServer
+	store *IdempotencyStore
+}
+
+// NewIdempotentService wraps service with idempotency key handling for Create, CreateAsync and Update
+func NewIdempotentService(service proto.OrderServiceServer, store *IdempotencyStore) proto.OrderServiceServer {
+	return idempotentService{OrderServiceServer: service, store: store}
+}
+
+// Create creates an order once per idempotency key
+func (s idempotentService) Create(ctx context.Context, req *proto.CreateOrderRequest) (*proto.CreateOrderResponse, error) {
+	resp, err := s.store.Do(ctx, proto.OrderService_Create_FullMethodName, req, func() (protobuf.Message, error) {
+		return s.OrderServiceServer.Create(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*proto.CreateOrderResponse), nil
+}
+
+// CreateAsync starts creating an order once per idempotency key. A replay returns the original operation
+func (s idempotentService) CreateAsync(ctx context.Context, req *proto.CreateOrderRequest) (*longrunningpb.Operation, error) {
+	resp, err := s.store.Do(ctx, proto.OrderService_CreateAsync_FullMethodName, req, func() (protobuf.Message, error) {
+		return s.OrderServiceServer.CreateAsync(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*longrunningpb.Operation), nil
+}
+
+// Update updates an order once per idempotency key
+func (s idempotentService) Update(ctx context.Context, req *proto.UpdateOrderRequest) (*proto.UpdateOrderResponse, error) {
+	resp, err := s.store.Do(ctx, proto.OrderService_Update_FullMethodName, req, func() (protobuf.Message, error) {
+		return s.OrderServiceServer.Update(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*proto.UpdateOrderResponse), nil
+}
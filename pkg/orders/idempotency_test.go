@@ -0,0 +1,141 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+func TestIdempotencyStoreDo(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour, time.Minute)
+	calls := 0
+	call := func() (protobuf.Message, error) {
+		calls++
+		return &proto.CreateOrderResponse{Order: &proto.Order{OrderId: int64(calls)}}, nil
+	}
+	ctxOf := func(subject, key string) context.Context {
+		return withIdempotencyKey(withIdentity(context.Background(), Identity{Subject: subject}), key)
+	}
+	req := &proto.CreateOrderRequest{Items: []*proto.Item{{Description: "a", Price: 1}}}
+	other := &proto.CreateOrderRequest{Items: []*proto.Item{{Description: "b", Price: 2}}}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		method  string
+		req     protobuf.Message
+		orderID int64 // Order of the response
+		err     error
+	}{
+		{name: "first call", ctx: ctxOf("alice", "k1"), method: "/m", req: req, orderID: 1},
+		{name: "replay", ctx: ctxOf("alice", "k1"), method: "/m", req: req, orderID: 1},
+		{name: "other request", ctx: ctxOf("alice", "k1"), method: "/m", req: other, err: ErrIdempotencyKeyReused},
+		{name: "other caller", ctx: ctxOf("bob", "k1"), method: "/m", req: req, orderID: 2},
+		{name: "other method", ctx: ctxOf("alice", "k1"), method: "/n", req: req, orderID: 3},
+		{name: "other key", ctx: ctxOf("alice", "k2"), method: "/m", req: req, orderID: 4},
+		{name: "no key", ctx: ctxOf("alice", ""), method: "/m", req: req, orderID: 5},
+		{name: "no key again", ctx: ctxOf("alice", ""), method: "/m", req: req, orderID: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := store.Do(tt.ctx, tt.method, tt.req, call)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Do() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if id := resp.(*proto.CreateOrderResponse).Order.OrderId; id != tt.orderID {
+				t.Errorf("Do() order = %d, want %d", id, tt.orderID)
+			}
+		})
+	}
+}
+
+func TestIdempotencyStoreInProgress(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour, time.Minute)
+	ctx := withIdempotencyKey(withIdentity(context.Background(), Identity{Subject: "alice"}), "k1")
+	req := &proto.CreateOrderRequest{}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		store.Do(ctx, "/m", req, func() (protobuf.Message, error) {
+			close(started)
+			<-release
+			return nil, errors.New("failed")
+		})
+	}()
+	<-started
+	if _, err := store.Do(ctx, "/m", req, nil); !errors.Is(err, ErrIdempotencyKeyInProgress) {
+		t.Errorf("Do() during the first call error = %v, want %v", err, ErrIdempotencyKeyInProgress)
+	}
+	close(release)
+	<-done
+
+	// Failed calls are not saved
+	resp, err := store.Do(ctx, "/m", req, func() (protobuf.Message, error) { return &proto.CreateOrderResponse{}, nil })
+	if err != nil || resp == nil {
+		t.Errorf("Do() after a failed call = %v, %v, want a new call", resp, err)
+	}
+}
+
+func TestIdempotencyStoreExpiresPending(t *testing.T) {
+	// A pending request expires long before the saved responses
+	store := NewIdempotencyStore(time.Hour, time.Millisecond)
+	ctx := withIdempotencyKey(withIdentity(context.Background(), Identity{Subject: "alice"}), "k1")
+	req := &proto.CreateOrderRequest{}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		store.Do(ctx, "/m", req, func() (protobuf.Message, error) {
+			close(started)
+			<-release
+			return nil, errors.New("lost")
+		})
+	}()
+	<-started
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := store.Do(ctx, "/m", req, func() (protobuf.Message, error) { return &proto.CreateOrderResponse{}, nil }); err != nil {
+		t.Errorf("Do() after the pending call expired error = %v, want a new call", err)
+	}
+	// The lost call ending later keeps the response of the new one
+	close(release)
+	<-lost
+	if _, err := store.Do(ctx, "/m", req, nil); err != nil {
+		t.Errorf("Do() replay after the lost call ended error = %v, want the saved response", err)
+	}
+}
+
+func TestIdempotencyStoreExpiresResponses(t *testing.T) {
+	store := NewIdempotencyStore(time.Millisecond, time.Hour)
+	req := &proto.CreateOrderRequest{}
+	calls := 0
+	call := func() (protobuf.Message, error) {
+		calls++
+		return &proto.CreateOrderResponse{}, nil
+	}
+	for _, key := range []string{"k1", "k2", "k3"} {
+		ctx := withIdempotencyKey(withIdentity(context.Background(), Identity{Subject: "alice"}), key)
+		if _, err := store.Do(ctx, "/m", req, call); err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	ctx := withIdempotencyKey(withIdentity(context.Background(), Identity{Subject: "alice"}), "k1")
+	if _, err := store.Do(ctx, "/m", req, call); err != nil || calls != 4 {
+		t.Errorf("Do() after the retention window = %v with %d calls, want a new call", err, calls)
+	}
+	if n := len(store.entries); n != 1 {
+		t.Errorf("store has %d entries, want the expired ones removed", n)
+	}
+}
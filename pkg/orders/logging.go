@@ -0,0 +1,169 @@
+package orders
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RequestIDHeader is the HTTP header carrying the request ID
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDMetadata is the gRPC metadata key carrying the request ID
+	RequestIDMetadata = "x-request-id"
+	// adminLogLevelMethod is the policy method name guarding the log level endpoint
+	adminLogLevelMethod = "/admin/LogLevel"
+)
+
+// LogLevel is the level of the structured logs. It can be changed at runtime
+var LogLevel = new(slog.LevelVar)
+
+type requestIDCtx struct{}
+
+// withRequestID returns a copy of ctx carrying the request ID
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDCtx{}, id)
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, if any
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtx{}).(string)
+	return id
+}
+
+// logHandler adds the request ID and the trace of the context to every record
+type logHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler wraps a slog handler so that records logged with a request context carry its
+// request ID, trace ID and span ID
+func NewLogHandler(h slog.Handler) slog.Handler {
+	return logHandler{Handler: h}
+}
+
+// Handle adds the request ID and the trace to the record
+func (h logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs keeps the request ID handling for derived handlers
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return logHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the request ID handling for derived handlers
+func (h logHandler) WithGroup(name string) slog.Handler {
+	return logHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// incomingRequestID takes the request ID from the incoming gRPC metadata, or generates one,
+// and sends it back in the response header
+func incomingRequestID(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadata); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = newID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadata, id))
+	return withRequestID(ctx, id)
+}
+
+// logRPC logs a finished gRPC call
+func logRPC(ctx context.Context, method string, start time.Time, err error) {
+	level := slog.LevelInfo
+	switch status.Code(err) {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "grpc call",
+		"method", method,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+}
+
+// loggingUnaryInterceptor assigns a request ID to unary calls and logs them
+func loggingUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx = incomingRequestID(ctx)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logRPC(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// loggingStreamInterceptor assigns a request ID to streaming calls and logs them
+func loggingStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := incomingRequestID(ss.Context())
+	start := time.Now()
+	err := handler(srv, contextStream{ServerStream: ss, ctx: ctx})
+	logRPC(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// loggingMiddleware takes the request ID from the X-Request-ID header, or generates one,
+// returns it in the response and logs the request
+func loggingMiddleware(c *gin.Context) {
+	id := c.GetHeader(RequestIDHeader)
+	if id == "" {
+		id = newID()
+	}
+	c.Header(RequestIDHeader, id)
+	ctx := withRequestID(c.Request.Context(), id)
+	c.Request = c.Request.WithContext(ctx)
+
+	start := time.Now()
+	c.Next()
+
+	level := slog.LevelInfo
+	if c.Writer.Status() >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "http request",
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"route", routeOf(c),
+		"status", c.Writer.Status(),
+		"duration", time.Since(start),
+		"client_ip", c.ClientIP(),
+	)
+}
+
+// getLogLevel is the handler function returning the current log level
+func getLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"level": LogLevel.Level().String()})
+}
+
+// setLogLevel is the handler function changing the log level from the request (e.g. {"level":"debug"})
+func setLogLevel(c *gin.Context) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	var level slog.Level
+	if err := c.ShouldBindJSON(&req); err != nil || level.UnmarshalText([]byte(req.Level)) != nil {
+		writeError(c, status.Error(codes.InvalidArgument, "invalid log level"))
+		return
+	}
+
+	LogLevel.Set(level)
+	slog.InfoContext(c.Request.Context(), "log level changed", "level", level.String())
+	c.JSON(http.StatusOK, gin.H{"level": level.String()})
+}
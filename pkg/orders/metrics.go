@@ -0,0 +1,136 @@
+package orders
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// metricsNamespace prefixes the names of the order service metrics
+const metricsNamespace = "orders"
+
+// Metrics of the order service, exposed on /metrics of the REST server
+var (
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC calls handled, by method and status code.",
+	}, []string{"method", "code"})
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Duration of gRPC calls, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "REST requests handled, by HTTP method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of REST requests, by HTTP method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	validationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "validation_duration_seconds",
+		Help:      "Duration of the order validation checks, by check (payment or inventory).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"check"})
+	validationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "validation_errors_total",
+		Help:      "Failed order validation checks, by check (payment or inventory).",
+	}, []string{"check"})
+
+	dispatcherQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dispatcher_queue_depth",
+		Help:      "Orders submitted to the dispatcher and waiting for fulfillment.",
+	})
+	dispatcherInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dispatcher_in_flight",
+		Help:      "Orders being fulfilled by the dispatcher.",
+	})
+	fulfillmentDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "fulfillment_duration_seconds",
+		Help:      "Duration of order fulfillment by the dispatcher.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+const (
+	// paymentCheck and inventoryCheck label the order validation checks
+	paymentCheck   = "payment"
+	inventoryCheck = "inventory"
+)
+
+// observeCheck records the duration and the outcome of a validation check started at start
+func observeCheck(check string, start time.Time, err error) {
+	validationDuration.WithLabelValues(check).Observe(time.Since(start).Seconds())
+	if err != nil {
+		validationErrors.WithLabelValues(check).Inc()
+	}
+}
+
+// observeRPC records a finished gRPC call
+func observeRPC(method string, start time.Time, err error) {
+	grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+	grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// metricsUnaryInterceptor counts and times unary calls
+func metricsUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+// metricsStreamInterceptor counts and times streaming calls
+func metricsStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
+
+// metricsMiddleware counts and times REST requests by route pattern, so that
+// IDs in the path do not create new series. Unknown routes share one series, and so do unknown methods
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := routeOf(c)
+	if route == "" {
+		route = "unmatched"
+	}
+	method := methodLabel(c.Request.Method)
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+}
+
+// methodLabel returns the label of an HTTP method: the method itself for the standard ones,
+// and OTHER for the others, which clients can choose freely
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
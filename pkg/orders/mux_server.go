@@ -0,0 +1,94 @@
+package orders
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// MuxServer serves native gRPC, gRPC-Web and the REST API on a single port, so that a single port
+// has to be opened and browsers can call the gRPC services. Requests are routed by content type:
+//   - application/grpc over HTTP/2 to the gRPC server
+//   - application/grpc-web (and its CORS preflight requests) to the gRPC server, through the gRPC-Web translation
+//   - anything else to the REST server, which serves the REST API and the Connect protocol
+//
+// Without TLS, HTTP/2 is served in cleartext (h2c) next to HTTP/1.1
+type MuxServer struct {
+	server   *http.Server
+	grpc     *grpc.Server
+	events   eventStreams
+	listener net.Listener
+	errCh    chan error
+}
+
+// NewMuxServer creates a MuxServer serving the services of the gRPC server and the routes of the REST server
+// on lis. Both must be created without TLS, which the MuxServer terminates if tlsConfig is not nil
+func NewMuxServer(grpcServer GrpcServer, restServer RestServer, tlsConfig *tls.Config, lis net.Listener) MuxServer {
+	// Browsers send bearer tokens, not cookies, so calls from any origin are safe to allow
+	grpcWeb := grpcweb.WrapServer(grpcServer.server, grpcweb.WithOriginFunc(func(string) bool { return true }))
+	rest := restServer.server.Handler
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case grpcWeb.IsGrpcWebRequest(r) || grpcWeb.IsAcceptableGrpcCorsRequest(r):
+			grpcWeb.ServeHTTP(w, r)
+		case r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc"):
+			grpcServer.server.ServeHTTP(w, r)
+		default:
+			rest.ServeHTTP(w, r)
+		}
+	})
+	if tlsConfig == nil {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	return MuxServer{
+		server: &http.Server{
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+		},
+		grpc:     grpcServer.server,
+		events:   restServer.events,
+		listener: lis,
+		errCh:    make(chan error),
+	}
+}
+
+// Start launches the server in the background, sending errors to the error channel
+func (m MuxServer) Start() {
+	go func() {
+		if m.server.TLSConfig != nil {
+			// The certificate comes from the TLS configuration
+			m.errCh <- m.server.ServeTLS(m.listener, "", "")
+			return
+		}
+		m.errCh <- m.server.Serve(m.listener)
+	}()
+}
+
+// Stop ends the event streams, stops accepting requests and waits for the active ones to finish,
+// for at most restShutdownTimeout, before closing the remaining connections and gRPC streams.
+// The gRPC server must not be stopped on its own: it cannot drain the calls it serves over HTTP
+func (m MuxServer) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), restShutdownTimeout)
+	defer cancel()
+	defer m.grpc.Stop()
+	m.events.close()
+	if err := m.server.Shutdown(ctx); err != nil {
+		return m.server.Close()
+	}
+	return nil
+}
+
+// Error returns the server's error channel
+func (m MuxServer) Error() chan error {
+	return m.errCh
+}
@@ -0,0 +1,475 @@
+package orders
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// openAPIVersion is the version of the OpenAPI specification of the documents
+const openAPIVersion = "3.0.3"
+
+// openAPIErrorSchema is the name of the schema of error responses
+const openAPIErrorSchema = "Error"
+
+// openAPIDocument builds the OpenAPI document of the routes of a gateway
+type openAPIDocument struct {
+	paths   map[string]map[string]any
+	schemas map[string]any
+}
+
+// OpenAPI returns the OpenAPI 3 document of the REST API of the gateway: a path per route,
+// with its parameters, request body and responses, and a schema per message.
+// It also describes the routes the REST server serves beside the gateway
+func (g *Gateway) OpenAPI(title, version string) map[string]any {
+	d := openAPIDocument{
+		paths: make(map[string]map[string]any),
+		schemas: map[string]any{
+			openAPIErrorSchema: map[string]any{
+				"type":        "object",
+				"description": "Error response of every route in JSON and YAML",
+				"properties":  map[string]any{"error": map[string]any{"type": "string"}},
+				"required":    []string{"error"},
+			},
+		},
+	}
+
+	operationIDs := make(map[string]int)
+	for _, route := range g.routes {
+		service, method, _ := strings.Cut(strings.TrimPrefix(route.fullMethod, "/"), "/")
+		service = service[strings.LastIndex(service, ".")+1:]
+
+		// Additional bindings of an RPC get numbered operation IDs
+		id := service + "_" + method
+		if n := operationIDs[id]; n > 0 {
+			id += "_" + strconv.Itoa(n)
+		}
+		operationIDs[service+"_"+method]++
+
+		op := d.operation(route, g.hooks[route.fullMethod])
+		op["operationId"] = id
+		op["tags"] = []string{service}
+		op["summary"] = method
+
+		path := d.path(route)
+		if d.paths[path] == nil {
+			d.paths[path] = make(map[string]any)
+		}
+		d.paths[path][strings.ToLower(route.httpMethod)] = op
+	}
+	d.addServerPaths()
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info":    map[string]any{"title": title, "version": version},
+		"paths":   d.paths,
+		"components": map[string]any{
+			"schemas": d.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     map[string]any{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+			},
+		},
+		"security": []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}},
+	}
+}
+
+// pathParameter is the OpenAPI path parameter of a wildcard of a route
+type pathParameter struct {
+	name        string
+	field       string // Field of the variable of the wildcard
+	whole       bool   // Whether the wildcard is the whole value of the field
+	description string
+}
+
+// pathParameters returns the path parameters of a route, by segment. A variable that is a single wildcard
+// is a parameter named after its field. In a variable with literals, such as {name=operations/*}, the wildcard
+// is only a segment of the field, and OpenAPI parameters cannot hold the slashes of the whole value:
+// it is named after the collection before it, such as operation_id, and the literals stay in the path
+func pathParameters(route *gatewayRoute) map[int]pathParameter {
+	isWildcard := func(s string) bool { return s == "*" || s == "**" }
+
+	params := make(map[int]pathParameter)
+	for _, v := range route.path.vars {
+		segments := route.path.segments[v.start:v.end]
+		if len(segments) == 1 && isWildcard(segments[0]) {
+			params[v.start] = pathParameter{name: v.field, field: v.field, whole: true}
+			continue
+		}
+
+		form := slices.Clone(segments)
+		names := make(map[int]string)
+		for i, s := range segments {
+			if !isWildcard(s) {
+				continue
+			}
+			name := v.field + "_" + strconv.Itoa(i)
+			if i > 0 && !isWildcard(segments[i-1]) {
+				name = strings.TrimSuffix(segments[i-1], "s") + "_id"
+			}
+			form[i] = "{" + name + "}"
+			names[v.start+i] = name
+		}
+		for i, name := range names {
+			params[i] = pathParameter{
+				name:        name,
+				field:       v.field,
+				description: "Segment of " + v.field + ", which is " + strings.Join(form, "/"),
+			}
+		}
+	}
+	return params
+}
+
+// path returns the OpenAPI path of a route, with its path parameters in place of the wildcards
+func (d openAPIDocument) path(route *gatewayRoute) string {
+	segments := slices.Clone(route.path.segments)
+	for i, param := range pathParameters(route) {
+		segments[i] = "{" + param.name + "}"
+	}
+	path := "/" + strings.Join(segments, "/")
+	if route.path.verb != "" {
+		path += ":" + route.path.verb
+	}
+	return path
+}
+
+// operation returns the OpenAPI operation of a route
+func (d openAPIDocument) operation(route *gatewayRoute, hook gatewayHook) map[string]any {
+	input := route.input.Descriptor()
+	var params []any
+
+	bound := make(map[string]bool)
+	for _, v := range route.path.vars {
+		bound[v.field] = true
+	}
+	pathParams := pathParameters(route)
+	for i := range route.path.segments {
+		param, ok := pathParams[i]
+		if !ok {
+			continue
+		}
+		schema := map[string]any{"type": "string"}
+		if param.whole {
+			fd, _ := fieldByPath(input, param.field)
+			schema = d.fieldSchema(fd)
+		}
+		p := map[string]any{"name": param.name, "in": "path", "required": true, "schema": schema}
+		if param.description != "" {
+			p["description"] = param.description
+		}
+		params = append(params, p)
+	}
+
+	// Without a body for the whole request, the other fields can be set by query parameters
+	if route.body != "*" {
+		fields := input.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			if bound[string(fd.Name())] || string(fd.Name()) == route.body || fd.IsMap() || isMessageField(fd) {
+				continue
+			}
+			params = append(params, map[string]any{"name": string(fd.Name()), "in": "query", "schema": d.fieldSchema(fd)})
+		}
+		for _, alias := range sortedKeys(hook.queryAliases) {
+			field := hook.queryAliases[alias]
+			if bound[field] {
+				continue
+			}
+			fd, _ := fieldByPath(input, field)
+			params = append(params, map[string]any{"name": alias, "in": "query", "description": "Same as " + field, "schema": d.fieldSchema(fd)})
+		}
+	}
+
+	for _, name := range sortedKeys(hook.headers) {
+		params = append(params, map[string]any{"name": name, "in": "header", "description": hook.headers[name], "schema": map[string]any{"type": "string"}})
+	}
+
+	op := map[string]any{}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	switch route.body {
+	case "":
+	case "*":
+		op["requestBody"] = d.requestBody(input)
+	default:
+		fd, _ := fieldByPath(input, route.body)
+		op["requestBody"] = d.requestBody(fd.Message())
+	}
+
+	responses := map[string]any{
+		"default": map[string]any{"description": "Error", "content": errorContent(d.ref(openAPIErrorSchema))},
+	}
+	success := hook.status
+	if success == 0 {
+		success = http.StatusOK
+	}
+	if output := route.output; output.FullName() == "google.protobuf.Empty" {
+		responses[strconv.Itoa(http.StatusNoContent)] = map[string]any{"description": "No content"}
+	} else {
+		responses[strconv.Itoa(success)] = map[string]any{"description": http.StatusText(success), "content": content(d.messageSchema(output))}
+	}
+	for code, description := range hook.statuses {
+		responses[strconv.Itoa(code)] = map[string]any{"description": description}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// requestBody returns the OpenAPI request body of a message
+func (d openAPIDocument) requestBody(md protoreflect.MessageDescriptor) map[string]any {
+	return map[string]any{"required": true, "content": content(d.messageSchema(md))}
+}
+
+// content returns the OpenAPI content of a body with the schema, in the media types of the codecs.
+// The schema of the JSON form describes YAML bodies too, and binary protobuf bodies encode the same message
+func content(schema map[string]any) map[string]any {
+	return map[string]any{
+		jsonCodec.mediaType:     map[string]any{"schema": schema},
+		yamlCodec.mediaType:     map[string]any{"schema": schema},
+		protobufCodec.mediaType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+	}
+}
+
+// addServerPaths adds the routes the REST server serves beside the gateway: the event streams,
+// the WebSocket order feed and the log level. Their bodies are in JSON only
+func (d openAPIDocument) addServerPaths() {
+	errorResponse := map[string]any{"description": "Error", "content": errorContent(d.ref(openAPIErrorSchema))}
+	events := map[string]any{
+		"description": "Server-Sent Events of the changes, with the position of the change in the feed as id, " +
+			"its kind (" + OrderCreated + ", " + OrderStatusChanged + " or " + OrderUpdated + ") as event type and the order as data, in the JSON form of the Order schema. " +
+			"A " + sseResetEvent + " event tells that changes since Last-Event-ID are no longer kept and that the orders must be retrieved again",
+		"content": map[string]any{"text/event-stream": map[string]any{
+			"schema":  map[string]any{"type": "string"},
+			"example": "id: 42\nevent: " + OrderStatusChanged + "\ndata: {\"orderId\":\"7\",\"status\":\"PAID\"}\n\n",
+		}},
+	}
+	lastEventID := map[string]any{
+		"name": "Last-Event-ID", "in": "header", "schema": map[string]any{"type": "string"},
+		"description": "Position of the last event received, to resume after it. Without it the stream starts with the next change",
+	}
+
+	d.paths["/order/{order_id}/events"] = map[string]any{"get": map[string]any{
+		"operationId": "OrderService_RetrieveEvents",
+		"tags":        []string{"OrderService"},
+		"summary":     "Streams the changes of an order",
+		"parameters": []any{
+			map[string]any{"name": "order_id", "in": "path", "required": true, "schema": map[string]any{"type": "string", "format": "int64"}},
+			lastEventID,
+		},
+		"responses": map[string]any{"200": events, "default": errorResponse},
+	}}
+	d.paths["/orders/events"] = map[string]any{"get": map[string]any{
+		"operationId": "OrderService_ListEvents",
+		"tags":        []string{"OrderService"},
+		"summary":     "Streams the changes of all the orders the caller may list",
+		"parameters":  []any{lastEventID},
+		"responses":   map[string]any{"200": events, "default": errorResponse},
+	}}
+	d.paths["/orders/ws"] = map[string]any{"get": map[string]any{
+		"operationId": "OrderService_Feed",
+		"tags":        []string{"OrderService"},
+		"summary":     "Order feed over a WebSocket",
+		"description": "Upgrades to a WebSocket of JSON messages. The client sends auth (with token or apiKey, first, " +
+			"when the upgrade request has no credentials), subscribe (id, orderIds, statuses, kinds), unsubscribe (id) and ack (seq) messages. " +
+			"The server sends ready, subscribed, unsubscribed, event (seq, kind, order, subscriptions), reset and error messages",
+		"parameters": []any{map[string]any{
+			"name": "after", "in": "query", "schema": map[string]any{"type": "string", "format": "int64"},
+			"description": "Position of the last event processed, to resume after it",
+		}},
+		// Browsers cannot set headers on WebSockets, and authenticate with the auth message instead
+		"security":  []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}, {}},
+		"responses": map[string]any{"101": map[string]any{"description": "Switching to the WebSocket protocol"}, "default": errorResponse},
+	}}
+
+	level := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"level": map[string]any{"type": "string", "description": "debug, info, warn or error"}},
+	}
+	levelContent := map[string]any{"application/json": map[string]any{"schema": level}}
+	d.paths["/admin/loglevel"] = map[string]any{
+		"get": map[string]any{
+			"operationId": "Admin_GetLogLevel",
+			"tags":        []string{"Admin"},
+			"summary":     "Returns the log level",
+			"responses":   map[string]any{"200": map[string]any{"description": "Log level", "content": levelContent}, "default": errorResponse},
+		},
+		"put": map[string]any{
+			"operationId": "Admin_SetLogLevel",
+			"tags":        []string{"Admin"},
+			"summary":     "Changes the log level",
+			"requestBody": map[string]any{"required": true, "content": levelContent},
+			"responses":   map[string]any{"200": map[string]any{"description": "New log level", "content": levelContent}, "default": errorResponse},
+		},
+	}
+}
+
+// errorContent returns the OpenAPI content of an error response with the schema of its JSON form.
+// In binary protobuf, errors are not encoded as that schema but as a google.rpc.Status
+func errorContent(schema map[string]any) map[string]any {
+	body := content(schema)
+	body[protobufCodec.mediaType] = map[string]any{"schema": map[string]any{
+		"type":        "string",
+		"format":      "binary",
+		"description": "A google.rpc.Status message, with the gRPC code, the error message and its details",
+	}}
+	return body
+}
+
+// ref returns a reference to a schema of the document
+func (d openAPIDocument) ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// messageSchema returns the schema of a message, as a reference to the schema added to the document.
+// Well-known types are inlined with the schema of their JSON form
+func (d openAPIDocument) messageSchema(md protoreflect.MessageDescriptor) map[string]any {
+	if schema, ok := wellKnownSchema(md); ok {
+		return schema
+	}
+
+	name := string(md.FullName())
+	if _, ok := d.schemas[name]; ok {
+		return d.ref(name)
+	}
+	properties := make(map[string]any)
+	schema := map[string]any{"type": "object", "properties": properties}
+	d.schemas[name] = schema // Added before the fields, as messages can be recursive
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		properties[fd.JSONName()] = d.fieldSchema(fd)
+	}
+	return d.ref(name)
+}
+
+// fieldSchema returns the schema of the JSON form of a field
+func (d openAPIDocument) fieldSchema(fd protoreflect.FieldDescriptor) map[string]any {
+	if fd.IsMap() {
+		return map[string]any{"type": "object", "additionalProperties": d.valueSchema(fd.MapValue())}
+	}
+	if fd.IsList() {
+		return map[string]any{"type": "array", "items": d.valueSchema(fd)}
+	}
+	return d.valueSchema(fd)
+}
+
+// valueSchema returns the schema of the JSON form of a single value of a field.
+// 64-bit integers are strings in JSON
+func (d openAPIDocument) valueSchema(fd protoreflect.FieldDescriptor) map[string]any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]any{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]any{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]any{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]any{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]any{"type": "number", "format": "double"}
+	case protoreflect.StringKind:
+		return map[string]any{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]any{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, values.Len())
+		for i := range names {
+			names[i] = string(values.Get(i).Name())
+		}
+		return map[string]any{"type": "string", "enum": names}
+	default:
+		return d.messageSchema(fd.Message())
+	}
+}
+
+// wellKnownSchema returns the schema of the JSON form of a well-known type
+func wellKnownSchema(md protoreflect.MessageDescriptor) (map[string]any, bool) {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]any{"type": "string", "format": "date-time"}, true
+	case "google.protobuf.Duration":
+		return map[string]any{"type": "string", "description": "Seconds with up to 9 fractional digits, followed by s (e.g. 1.5s)"}, true
+	case "google.protobuf.FieldMask":
+		return map[string]any{"type": "string", "description": "Comma-separated field paths in lowerCamelCase"}, true
+	case "google.protobuf.Any":
+		return map[string]any{
+			"type":                 "object",
+			"description":          "Message of the type named by @type, with its fields",
+			"properties":           map[string]any{"@type": map[string]any{"type": "string"}},
+			"additionalProperties": true,
+		}, true
+	case "google.protobuf.Empty":
+		return map[string]any{"type": "object"}, true
+	case "google.protobuf.Struct":
+		return map[string]any{"type": "object", "additionalProperties": true}, true
+	case "google.protobuf.Value":
+		return map[string]any{}, true
+	case "google.protobuf.StringValue", "google.protobuf.BytesValue", "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return map[string]any{"type": "string"}, true
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return map[string]any{"type": "integer"}, true
+	case "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		return map[string]any{"type": "number"}, true
+	case "google.protobuf.BoolValue":
+		return map[string]any{"type": "boolean"}, true
+	}
+	return nil, false
+}
+
+// isMessageField tells whether a field holds messages that have no string form in a query parameter
+func isMessageField(fd protoreflect.FieldDescriptor) bool {
+	if fd.Kind() != protoreflect.MessageKind {
+		return false
+	}
+	schema, ok := wellKnownSchema(fd.Message())
+	return !ok || schema["type"] == "object"
+}
+
+// sortedKeys returns the keys of a map of strings in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// docsInitializer configures the API viewer with the OpenAPI document of the server
+const docsInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "/openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// serveDocs serves the API viewer under /docs/, from files embedded in the binary so that it works offline
+func serveDocs() gin.HandlerFunc {
+	files := http.StripPrefix("/docs", http.FileServer(http.FS(swaggerFiles.FS)))
+	return func(c *gin.Context) {
+		switch c.Param("file") {
+		case "/swagger-initializer.js":
+			c.Data(http.StatusOK, "text/javascript; charset=utf-8", []byte(docsInitializer))
+		default:
+			files.ServeHTTP(c.Writer, c.Request)
+		}
+	}
+}
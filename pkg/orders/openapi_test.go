@@ -0,0 +1,42 @@
+package orders
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOpenAPIPath(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+		params   []string // Names of the path parameters
+	}{
+		{"/v1/orders", "/v1/orders", nil},
+		{"/v1/orders/{order_id}:updateStatus", "/v1/orders/{order_id}:updateStatus", []string{"order_id"}},
+		{"/v1/{name=operations/*}", "/v1/operations/{operation_id}", []string{"operation_id"}},
+		{"/v1/{name=operations/*}:cancel", "/v1/operations/{operation_id}:cancel", []string{"operation_id"}},
+		{"/v1/{name=operations}", "/v1/operations", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			template, err := parsePathTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("parsePathTemplate() error = %v", err)
+			}
+			route := &gatewayRoute{path: template}
+			if got := (openAPIDocument{}).path(route); got != tt.want {
+				t.Errorf("path() = %q, want %q", got, tt.want)
+			}
+			var names []string
+			params := pathParameters(route)
+			for i := range template.segments {
+				if param, ok := params[i]; ok {
+					names = append(names, param.name)
+				}
+			}
+			if !slices.Equal(names, tt.params) {
+				t.Errorf("pathParameters() = %v, want %v", names, tt.params)
+			}
+		})
+	}
+}
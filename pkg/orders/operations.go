@@ -0,0 +1,303 @@
+package orders
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	// operationsCollection is the resource collection of operation names
+	operationsCollection = "operations"
+	// maxWaitOperation bounds how long WaitOperation blocks without a timeout
+	maxWaitOperation = 30 * time.Second
+	// defaultOperationsPageSize is the page size of ListOperations when none is given
+	defaultOperationsPageSize = 50
+)
+
+var (
+	ErrOperationNotFound = status.Error(codes.NotFound, "operation not found")
+	ErrOperationsStopped = status.Error(codes.Unavailable, "the service is shutting down")
+)
+
+// operationsServiceDesc describes the operations service for the REST gateway, as the generated one is not exported
+var operationsServiceDesc = grpc.ServiceDesc{
+	ServiceName: operationsServiceName,
+	HandlerType: (*longrunningpb.OperationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "ListOperations", Handler: unaryHandler(operationsListFullMethodName, longrunningpb.OperationsServer.ListOperations)},
+		{MethodName: "GetOperation", Handler: unaryHandler(operationsGetFullMethodName, longrunningpb.OperationsServer.GetOperation)},
+		{MethodName: "DeleteOperation", Handler: unaryHandler(operationsDeleteFullMethodName, longrunningpb.OperationsServer.DeleteOperation)},
+		{MethodName: "CancelOperation", Handler: unaryHandler(operationsCancelFullMethodName, longrunningpb.OperationsServer.CancelOperation)},
+		{MethodName: "WaitOperation", Handler: unaryHandler(operationsWaitFullMethodName, longrunningpb.OperationsServer.WaitOperation)},
+	},
+}
+
+// unaryHandler returns the handler of a unary RPC calling the method of the service, like the generated ones
+func unaryHandler[S, Req, Res any](fullMethod string, method func(S, context.Context, *Req) (Res, error)) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(Req)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return method(srv.(S), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+		return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+			return method(srv.(S), ctx, req.(*Req))
+		})
+	}
+}
+
+// operation is a long-running task tracked by Operations
+type operation struct {
+	op       *longrunningpb.Operation
+	owner    string // Subject of the caller who started the operation
+	created  time.Time
+	finished time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Operations runs long-running tasks in the background and implements the
+// google.longrunning.Operations service to get, list, wait on and cancel them
+type Operations struct {
+	longrunningpb.UnimplementedOperationsServer
+	mu        sync.Mutex
+	ops       map[string]*operation
+	retention time.Duration
+	running   sync.WaitGroup // Tasks not finished yet
+	stopped   bool           // Set by Shutdown, which rejects new operations
+}
+
+// NewOperations creates an Operations keeping finished operations for the retention window
+func NewOperations(retention time.Duration) *Operations {
+	return &Operations{
+		ops:       make(map[string]*operation),
+		retention: retention,
+	}
+}
+
+// Start runs task in the background and returns its pending operation. The task gets
+// the values of ctx but is not canceled with it; it is canceled through CancelOperation.
+// Callers whose grant is limited to their own resources only see the operations they started
+func (o *Operations) Start(ctx context.Context, metadata protobuf.Message, task func(ctx context.Context) (protobuf.Message, error)) (*longrunningpb.Operation, error) {
+	meta, err := anypb.New(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	id, _ := IdentityFromContext(ctx)
+	op := &operation{
+		op:      &longrunningpb.Operation{Name: operationsCollection + "/" + newID(), Metadata: meta},
+		owner:   id.Subject,
+		created: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	o.mu.Lock()
+	if o.stopped {
+		o.mu.Unlock()
+		cancel()
+		return nil, ErrOperationsStopped
+	}
+	o.purgeExpired()
+	o.ops[op.op.Name] = op
+	snapshot := protobuf.Clone(op.op).(*longrunningpb.Operation)
+	o.running.Add(1)
+	o.mu.Unlock()
+
+	go func() {
+		defer o.running.Done()
+		defer cancel()
+		resp, err := task(taskCtx)
+		o.finish(op, resp, err)
+	}()
+
+	return snapshot, nil
+}
+
+// finish records the result of an operation's task. The operation is cancelled only if the task
+// returned a CANCELLED error: a task cancelled too late to stop it keeps its result
+func (o *Operations) finish(op *operation, resp protobuf.Message, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer close(op.done)
+
+	op.op.Done = true
+	op.finished = time.Now()
+	switch {
+	case status.Code(err) == codes.Canceled:
+		op.op.Result = &longrunningpb.Operation_Error{Error: status.New(codes.Canceled, "operation was cancelled").Proto()}
+	case err != nil:
+		op.op.Result = &longrunningpb.Operation_Error{Error: status.Convert(err).Proto()}
+	default:
+		result, err := anypb.New(resp)
+		if err != nil {
+			op.op.Result = &longrunningpb.Operation_Error{Error: status.Convert(err).Proto()}
+			return
+		}
+		op.op.Result = &longrunningpb.Operation_Response{Response: result}
+	}
+}
+
+// Shutdown rejects new operations, cancels the running ones and waits for their tasks to return
+func (o *Operations) Shutdown() {
+	o.mu.Lock()
+	o.stopped = true
+	for _, op := range o.ops {
+		op.cancel()
+	}
+	o.mu.Unlock()
+	o.running.Wait()
+}
+
+// purgeExpired removes finished operations past the retention window. The caller must hold o.mu
+func (o *Operations) purgeExpired() {
+	now := time.Now()
+	for name, op := range o.ops {
+		if op.op.Done && now.Sub(op.finished) > o.retention {
+			delete(o.ops, name)
+		}
+	}
+}
+
+// lookup returns the operation with the given name. Operations the caller's grant does not give access to are hidden
+func (o *Operations) lookup(ctx context.Context, name string) (*operation, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	op, ok := o.ops[name]
+	if !ok {
+		return nil, ErrOperationNotFound
+	}
+	if !ownsResource(ctx, op.owner) {
+		id, _ := IdentityFromContext(ctx)
+		slog.WarnContext(ctx, "access denied", "reason", "operation not owned", "subject", id.Subject, "roles", id.Roles, "operation", name)
+		return nil, ErrOperationNotFound
+	}
+	return op, nil
+}
+
+// snapshot returns a copy of the current state of an operation
+func (o *Operations) snapshot(op *operation) *longrunningpb.Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return protobuf.Clone(op.op).(*longrunningpb.Operation)
+}
+
+// GetOperation returns the latest state of an operation
+func (o *Operations) GetOperation(ctx context.Context, req *longrunningpb.GetOperationRequest) (*longrunningpb.Operation, error) {
+	op, err := o.lookup(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return o.snapshot(op), nil
+}
+
+// ListOperations lists the operations the caller has access to, from the oldest to the newest. Filters are not supported
+func (o *Operations) ListOperations(ctx context.Context, req *longrunningpb.ListOperationsRequest) (*longrunningpb.ListOperationsResponse, error) {
+	if req.Filter != "" {
+		return nil, status.Error(codes.InvalidArgument, "operation filters are not supported")
+	}
+	offset := 0
+	if req.PageToken != "" {
+		var err error
+		if offset, err = strconv.Atoi(req.PageToken); err != nil || offset < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	}
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultOperationsPageSize
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ops := make([]*operation, 0, len(o.ops))
+	for _, op := range o.ops {
+		if ownsResource(ctx, op.owner) {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].created.Before(ops[j].created) })
+
+	resp := &longrunningpb.ListOperationsResponse{}
+	for i := offset; i < len(ops) && i < offset+pageSize; i++ {
+		resp.Operations = append(resp.Operations, protobuf.Clone(ops[i].op).(*longrunningpb.Operation))
+	}
+	if offset+pageSize < len(ops) {
+		resp.NextPageToken = strconv.Itoa(offset + pageSize)
+	}
+	return resp, nil
+}
+
+// DeleteOperation forgets an operation. A running task is not cancelled
+func (o *Operations) DeleteOperation(ctx context.Context, req *longrunningpb.DeleteOperationRequest) (*emptypb.Empty, error) {
+	if _, err := o.lookup(ctx, req.Name); err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.ops, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+// CancelOperation requests the cancellation of a running operation.
+// The operation finishes with a CANCELLED error if the task is cancelled in time
+func (o *Operations) CancelOperation(ctx context.Context, req *longrunningpb.CancelOperationRequest) (*emptypb.Empty, error) {
+	op, err := o.lookup(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	op.cancel()
+	return &emptypb.Empty{}, nil
+}
+
+// WaitOperation waits until an operation is done or the timeout (at most 30 seconds) expires,
+// and returns its latest state
+func (o *Operations) WaitOperation(ctx context.Context, req *longrunningpb.WaitOperationRequest) (*longrunningpb.Operation, error) {
+	op, err := o.lookup(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := maxWaitOperation
+	if t := req.GetTimeout(); t != nil && t.AsDuration() > 0 && t.AsDuration() < timeout {
+		timeout = t.AsDuration()
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-op.done:
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return o.snapshot(op), nil
+}
+
+// newID returns a random ID for operations and requests
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
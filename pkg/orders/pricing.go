@@ -0,0 +1,55 @@
+package orders
+
+import (
+	"math"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+)
+
+// Pricing holds the rules used to price an order
+type Pricing struct {
+	TaxRate          float32 // Share of the item price added as tax
+	ShippingFee      float32 // Flat shipping fee per order
+	FreeShippingFrom float32 // Subtotal from which shipping is free, 0 disables free shipping
+}
+
+// DefaultPricing is the pricing used by the order service
+var DefaultPricing = Pricing{
+	TaxRate:          0.20,
+	ShippingFee:      4.99,
+	FreeShippingFrom: 50,
+}
+
+// orderPrice is the price breakdown of an order
+type orderPrice struct {
+	itemTax  []float32 // Tax of each item, in item order
+	subtotal float32
+	tax      float32
+	shipping float32
+	total    float32
+}
+
+// price returns the price breakdown of the items. Amounts are rounded to cents
+func (p Pricing) price(items []*proto.Item) orderPrice {
+	var price orderPrice
+
+	for _, item := range items {
+		tax := roundCents(item.Price * p.TaxRate)
+		price.itemTax = append(price.itemTax, tax)
+		price.tax += tax
+	}
+	price.subtotal = roundCents(getOrderTotal(items))
+	price.tax = roundCents(price.tax)
+
+	if len(items) > 0 && (p.FreeShippingFrom == 0 || price.subtotal < p.FreeShippingFrom) {
+		price.shipping = p.ShippingFee
+	}
+	price.total = roundCents(price.subtotal + price.tax + price.shipping)
+
+	return price
+}
+
+// roundCents rounds an amount to cents
+func roundCents(amount float32) float32 {
+	return float32(math.Round(float64(amount)*100) / 100)
+}
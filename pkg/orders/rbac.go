@@ -0,0 +1,221 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// Full method names of the operations service, which has no generated constants
+const (
+	operationsGetFullMethodName    = "/google.longrunning.Operations/GetOperation"
+	operationsListFullMethodName   = "/google.longrunning.Operations/ListOperations"
+	operationsDeleteFullMethodName = "/google.longrunning.Operations/DeleteOperation"
+	operationsCancelFullMethodName = "/google.longrunning.Operations/CancelOperation"
+	operationsWaitFullMethodName   = "/google.longrunning.Operations/WaitOperation"
+)
+
+var ErrPermissionDenied = status.Error(codes.PermissionDenied, "permission denied")
+
+// Permission allows a role to call an RPC
+type Permission struct {
+	// Method is the full RPC name without the leading slash, e.g. "orders.OrderService/Retrieve".
+	// Shell patterns such as "orders.OrderService/*" match several RPCs
+	Method string `yaml:"method"`
+	// Own limits the RPC to orders and operations created by the caller
+	Own bool `yaml:"own"`
+	// Statuses limits the order statuses the caller may set, all statuses if empty
+	Statuses []string `yaml:"statuses"`
+}
+
+// Policy maps roles to their permissions
+type Policy struct {
+	Roles map[string][]Permission `yaml:"roles"`
+}
+
+// LoadPolicy reads a YAML policy file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for role, perms := range p.Roles {
+		for _, perm := range perms {
+			if _, err := path.Match(perm.Method, ""); err != nil || perm.Method == "" {
+				return nil, fmt.Errorf("%s: role %q: invalid method %q", file, role, perm.Method)
+			}
+			for _, s := range perm.Statuses {
+				if _, ok := proto.Order_Status_value[s]; !ok {
+					return nil, fmt.Errorf("%s: role %q: unknown status %q", file, role, s)
+				}
+			}
+		}
+	}
+	return &p, nil
+}
+
+// grant is what the caller's roles allow for one RPC. The zero grant is the most restrictive:
+// the caller's own orders and operations only, and no status
+type grant struct {
+	all         bool                        // all orders and operations, not only the caller's own
+	allStatuses bool                        // any status, regardless of statuses
+	statuses    map[proto.Order_Status]bool // statuses the caller may set
+}
+
+type grantCtx struct{}
+
+// grantFromContext returns the grant of the request. Requests that were not
+// authorized by a Policy get the zero grant, so that a route missing the policy fails closed
+func grantFromContext(ctx context.Context) grant {
+	g, _ := ctx.Value(grantCtx{}).(grant)
+	return g
+}
+
+// withGrant returns ctx carrying the grant g
+func withGrant(ctx context.Context, g grant) context.Context {
+	return context.WithValue(ctx, grantCtx{}, g)
+}
+
+// Authorize checks that the caller's roles allow the RPC and returns ctx carrying
+// the resulting grant. When several roles allow the RPC, the least restrictive grant wins
+func (p *Policy) Authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	id, ok := IdentityFromContext(ctx)
+	if !ok {
+		return nil, ErrMissingToken
+	}
+	method := strings.TrimPrefix(fullMethod, "/")
+
+	var g grant
+	allowed := false
+	for _, role := range id.Roles {
+		for _, perm := range p.Roles[role] {
+			if match, _ := path.Match(perm.Method, method); !match {
+				continue
+			}
+			g = mergeGrant(g, perm)
+			allowed = true
+		}
+	}
+	if !allowed {
+		slog.WarnContext(ctx, "access denied", "reason", "method not allowed", "subject", id.Subject, "roles", id.Roles, "method", method)
+		return nil, ErrPermissionDenied
+	}
+	return withGrant(ctx, g), nil
+}
+
+// mergeGrant widens g with a permission. Merging into the zero grant gives the grant of the permission
+func mergeGrant(g grant, perm Permission) grant {
+	g.all = g.all || !perm.Own
+	if len(perm.Statuses) == 0 {
+		g.allStatuses, g.statuses = true, nil
+		return g
+	}
+	if g.allStatuses {
+		return g
+	}
+	if g.statuses == nil {
+		g.statuses = make(map[proto.Order_Status]bool)
+	}
+	for s := range statusSet(perm.Statuses) {
+		g.statuses[s] = true
+	}
+	return g
+}
+
+// statusSet converts status names to a set
+func statusSet(names []string) map[proto.Order_Status]bool {
+	set := make(map[proto.Order_Status]bool, len(names))
+	for _, name := range names {
+		set[proto.Order_Status(proto.Order_Status_value[name])] = true
+	}
+	return set
+}
+
+// UnaryInterceptor rejects unary calls the caller's roles do not allow, except to public methods
+func (p *Policy) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	ctx, err := p.Authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor rejects streaming calls the caller's roles do not allow, except to public methods
+func (p *Policy) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if publicMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+	ctx, err := p.Authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// Require returns a middleware rejecting REST requests the caller's roles do not allow
+// for the RPC behind the route with 403 Forbidden
+func (p *Policy) Require(fullMethod string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, err := p.Authorize(c.Request.Context(), fullMethod)
+		if err != nil {
+			writeError(c, err)
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// ownsOrder tells whether the caller's grant gives access to the order:
+// either the grant is not limited to the caller's own orders, or the caller created the order
+func ownsOrder(ctx context.Context, order *proto.Order) bool {
+	return ownsResource(ctx, order.CustomerId)
+}
+
+// ownsResource tells whether the caller's grant gives access to a resource created by owner
+func ownsResource(ctx context.Context, owner string) bool {
+	if grantFromContext(ctx).all {
+		return true
+	}
+	id, ok := IdentityFromContext(ctx)
+	return ok && id.Subject == owner
+}
+
+// checkOwner hides an order the caller's grant does not give access to
+func checkOwner(ctx context.Context, order *proto.Order) error {
+	if ownsOrder(ctx, order) {
+		return nil
+	}
+	id, _ := IdentityFromContext(ctx)
+	slog.WarnContext(ctx, "access denied", "reason", "order not owned", "subject", id.Subject, "roles", id.Roles, "order_id", order.OrderId)
+	return ErrOrderNotFound
+}
+
+// checkStatusAllowed rejects setting a status the caller's grant does not include
+func checkStatusAllowed(ctx context.Context, s proto.Order_Status) error {
+	if g := grantFromContext(ctx); !g.allStatuses && !g.statuses[s] {
+		id, _ := IdentityFromContext(ctx)
+		slog.WarnContext(ctx, "access denied", "reason", "status not allowed", "subject", id.Subject, "roles", id.Roles, "status", s.String())
+		return status.Errorf(codes.PermissionDenied, "permission denied to set status %s", s)
+	}
+	return nil
+}
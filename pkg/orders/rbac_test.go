@@ -0,0 +1,115 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testPolicy has roles of policy.yaml, trimmed to the RPCs the tests call, and a role limited both
+// to its own orders and to some statuses
+var testPolicy = &Policy{Roles: map[string][]Permission{
+	"customer": {
+		{Method: "orders.OrderService/Retrieve", Own: true},
+		{Method: "orders.OrderService/Create"},
+	},
+	"support": {
+		{Method: "orders.OrderService/Retrieve"},
+		{Method: "orders.OrderService/UpdateStatus"},
+	},
+	"warehouse": {
+		{Method: "orders.OrderService/Retrieve"},
+		{Method: "orders.OrderService/UpdateStatus", Statuses: []string{"SHIPPED", "DELIVERED"}},
+	},
+	"packer": {
+		{Method: "orders.OrderService/UpdateStatus", Own: true, Statuses: []string{"PAID"}},
+	},
+}}
+
+func TestPolicyAuthorize(t *testing.T) {
+	alices := &proto.Order{OrderId: 1, CustomerId: "alice"}
+	bobs := &proto.Order{OrderId: 2, CustomerId: "bob"}
+
+	tests := []struct {
+		name     string
+		roles    []string
+		method   string
+		err      error
+		owns     []*proto.Order // Orders the caller gets access to, of alice's and bob's
+		statuses []proto.Order_Status
+	}{
+		{name: "own orders only", roles: []string{"customer"}, method: proto.OrderService_Retrieve_FullMethodName, owns: []*proto.Order{alices}},
+		{name: "all orders", roles: []string{"support"}, method: proto.OrderService_Retrieve_FullMethodName, owns: []*proto.Order{alices, bobs}},
+		{name: "widest role wins", roles: []string{"customer", "support"}, method: proto.OrderService_Retrieve_FullMethodName, owns: []*proto.Order{alices, bobs}},
+		{name: "method not allowed", roles: []string{"customer"}, method: proto.OrderService_UpdateStatus_FullMethodName, err: ErrPermissionDenied},
+		{name: "no roles", method: proto.OrderService_Retrieve_FullMethodName, err: ErrPermissionDenied},
+		{
+			name: "all statuses", roles: []string{"support"}, method: proto.OrderService_UpdateStatus_FullMethodName,
+			owns: []*proto.Order{alices, bobs}, statuses: []proto.Order_Status{proto.Order_PAID, proto.Order_SHIPPED, proto.Order_DELIVERED},
+		},
+		{
+			name: "some statuses", roles: []string{"warehouse"}, method: proto.OrderService_UpdateStatus_FullMethodName,
+			owns: []*proto.Order{alices, bobs}, statuses: []proto.Order_Status{proto.Order_SHIPPED, proto.Order_DELIVERED},
+		},
+		{
+			name: "statuses of both roles", roles: []string{"warehouse", "packer"}, method: proto.OrderService_UpdateStatus_FullMethodName,
+			owns: []*proto.Order{alices, bobs}, statuses: []proto.Order_Status{proto.Order_PAID, proto.Order_SHIPPED, proto.Order_DELIVERED},
+		},
+		{
+			name: "own orders and some statuses", roles: []string{"packer"}, method: proto.OrderService_UpdateStatus_FullMethodName,
+			owns: []*proto.Order{alices}, statuses: []proto.Order_Status{proto.Order_PAID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := withIdentity(context.Background(), Identity{Subject: "alice", Roles: tt.roles})
+			ctx, err := testPolicy.Authorize(ctx, tt.method)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Authorize() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			for _, order := range []*proto.Order{alices, bobs} {
+				want := false
+				for _, owned := range tt.owns {
+					want = want || owned == order
+				}
+				if got := checkOwner(ctx, order) == nil; got != want {
+					t.Errorf("checkOwner(order of %s) allowed = %v, want %v", order.CustomerId, got, want)
+				}
+			}
+			if tt.method != proto.OrderService_UpdateStatus_FullMethodName {
+				return
+			}
+			for _, s := range []proto.Order_Status{proto.Order_PAID, proto.Order_SHIPPED, proto.Order_DELIVERED} {
+				want := false
+				for _, allowed := range tt.statuses {
+					want = want || allowed == s
+				}
+				if err := checkStatusAllowed(ctx, s); (err == nil) != want {
+					t.Errorf("checkStatusAllowed(%s) = %v, want allowed %v", s, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGrantWithoutPolicy(t *testing.T) {
+	// A request that skipped the policy is limited to its own orders and may set no status
+	ctx := withIdentity(context.Background(), Identity{Subject: "alice"})
+	if err := checkOwner(ctx, &proto.Order{CustomerId: "bob"}); status.Code(err) != codes.NotFound {
+		t.Errorf("checkOwner() of another caller's order error = %v, want NotFound", err)
+	}
+	if err := checkOwner(ctx, &proto.Order{CustomerId: "alice"}); err != nil {
+		t.Errorf("checkOwner() of the caller's order error = %v", err)
+	}
+	if err := checkStatusAllowed(ctx, proto.Order_SHIPPED); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("checkStatusAllowed() error = %v, want PermissionDenied", err)
+	}
+}
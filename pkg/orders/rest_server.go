@@ -1,12 +1,26 @@
 package orders
 
 import (
-	"io"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
 	"github.com/AndreiMartynenko/grpc-eshop/proto"
 	"github.com/gin-gonic/gin"
-	"google.golang.org/protobuf/encoding/protojson"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
 )
 
 // RestServer implements a REST server for the order service
@@ -14,34 +28,210 @@ import (
 // optimize REST and gRPC servers for background processing and error propagation through channels.
 
 type RestServer struct {
-	server       *http.Server
-	orderService proto.OrderServiceServer // The same order service as in the gRPC server
-	errCh        chan error               // Optimization. Adding channel
+	server   *http.Server
+	listener net.Listener
+	gateway  *Gateway        // Serves the order and operations services from their google.api.http rules
+	connect  *connectHandler // Serves the order and operations services over the Connect protocol
+	events   eventStreams    // Streams the order changes as Server-Sent Events
+	errCh    chan error      // Optimization. Adding channel
 }
 
-var router = gin.Default() // Declare a global router
+const (
+	// restShutdownTimeout bounds how long Stop waits for the active requests
+	restShutdownTimeout = 30 * time.Second
+	// Title and version of the OpenAPI document of the REST API
+	openAPITitle      = "Order service"
+	openAPIDocVersion = "1.0.0"
+)
+
+var router *gin.Engine // Declare a global router, created by NewRestServer
 
-// The NewRestServer function is perfect for creating a RestServer
-func NewRestServer(orderService proto.OrderServiceServer, port string) RestServer {
+// The NewRestServer function is perfect for creating a RestServer.
+// It also serves the order and operations services over the Connect protocol
+// under /orders.OrderService/ and /google.longrunning.Operations/.
+// Every request must carry a bearer token accepted by auth, and the policy
+// must allow the RPC behind the route, except the metrics, the health endpoints and the API documentation:
+// the OpenAPI document of the routes at /openapi.json and a viewer for it at /docs/.
+// Request bodies are decoded as set by decoding. The changes of the feed are streamed as Server-Sent Events
+// at /order/:id/events and /orders/events, and over a WebSocket at /orders/ws.
+// The server uses TLS if tlsConfig is not nil, and serves on lis
+func NewRestServer(orderService proto.OrderServiceServer, operations longrunningpb.OperationsServer, changes *ChangeFeed, health *Health, auth *Authenticator, policy *Policy, decoding BodyDecoding, tlsConfig *tls.Config, lis net.Listener) RestServer {
+	router = gin.New()
+	// Connect calls are authorized by the interceptors of the gRPC server instead of the gin middleware
+	connectHandler := newConnectHandler(orderService, operations, auth, policy, decoding.MaxSize)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connectHandler.serves(req.URL.Path) {
+			connectHandler.ServeHTTP(w, req)
+			return
+		}
+		router.ServeHTTP(w, req)
+	})
 	rs := RestServer{
 		server: &http.Server{
-			Addr:    ":" + port,
-			Handler: router,
+			Handler:   handler,
+			TLSConfig: tlsConfig,
 		},
-		orderService: orderService,
-		errCh:        make(chan error), // Optimization
+		listener: lis,
+		gateway:  NewGateway(policy.UnaryInterceptor, operationsHTTPRules),
+		connect:  connectHandler,
+		events:   newEventStreams(changes, orderService, auth, policy),
+		errCh:    make(chan error), // Optimization
+	}
+
+	rs.gateway.SetBodyDecoding(decoding)
+
+	// The routes of the order and operations services come from their google.api.http rules.
+	// Each call requires the permission of the RPC
+	proto.RegisterOrderServiceServer(rs.gateway, orderService)
+	rs.gateway.RegisterService(&operationsServiceDesc, operations)
+	rs.hookOrderService()
+	openAPI, err := json.Marshal(rs.gateway.OpenAPI(openAPITitle, openAPIDocVersion))
+	if err != nil {
+		panic(err)
 	}
 
-	// Route registration
-	router.POST("/order", rs.create)
-	router.GET("/order/:id", rs.retrieve)
-	router.PUT("/order", rs.update)
-	router.DELETE("/order", rs.delete)
-	router.GET("/order", rs.list)
+	// Prometheus and the orchestrator probes call these without credentials, so they are registered before the middleware.
+	// So is the API documentation, for partners to read before they have credentials
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/healthz", health.liveness)
+	router.GET("/readyz", health.readiness)
+	router.GET("/openapi.json", func(c *gin.Context) { c.Data(http.StatusOK, "application/json", openAPI) })
+	router.GET("/docs/*file", serveDocs())
+
+	// Spans are started from the W3C trace context of the incoming headers
+	router.Use(otelgin.Middleware(serviceName), loggingMiddleware, metricsMiddleware, gin.Recovery())
+	// WebSocket connections are authenticated by the order feed, as browsers cannot set their headers
+	router.GET("/orders/ws", rs.events.websocket)
+	router.Use(auth.Middleware, idempotencyKeyMiddleware)
+
+	router.NoRoute(rs.gateway.serve)
+
+	// Streaming the changes of an order requires the permission to retrieve it, and streaming
+	// the changes of all orders the permission to list them
+	router.GET("/order/:id/events", policy.Require(proto.OrderService_Retrieve_FullMethodName), rs.events.order)
+	router.GET("/orders/events", policy.Require(proto.OrderService_List_FullMethodName), rs.events.orders)
+
+	router.GET("/admin/loglevel", policy.Require(adminLogLevelMethod), getLogLevel)
+	router.PUT("/admin/loglevel", policy.Require(adminLogLevelMethod), setLogLevel)
 
 	return rs
 }
 
+// operationsHTTPRules maps WaitOperation, which google/longrunning/operations.proto leaves without a route,
+// and keeps the routes of the operations of the first REST API working
+var operationsHTTPRules = map[string][]*annotations.HttpRule{
+	operationsListFullMethodName: {
+		{Pattern: &annotations.HttpRule_Get{Get: "/{name=operations}"}},
+	},
+	operationsGetFullMethodName: {
+		{Pattern: &annotations.HttpRule_Get{Get: "/{name=operations/*}"}},
+	},
+	operationsDeleteFullMethodName: {
+		{Pattern: &annotations.HttpRule_Delete{Delete: "/{name=operations/*}"}},
+	},
+	operationsCancelFullMethodName: {
+		{Pattern: &annotations.HttpRule_Post{Post: "/{name=operations/*}/cancel"}},
+	},
+	operationsWaitFullMethodName: {
+		{Pattern: &annotations.HttpRule_Post{Post: "/v1/{name=operations/**}:wait"}, Body: "*"},
+		{Pattern: &annotations.HttpRule_Post{Post: "/{name=operations/*}/wait"}},
+	},
+}
+
+// hookOrderService adds the REST conventions of the order routes:
+//   - "Prefer: respond-async" creates the order in the background, responding with 202 Accepted
+//     and the location of the operation tracking it
+//   - responses carry the order's ETag, and a retrieval is 304 Not Modified if it matches If-None-Match
+//   - changes apply only if the order matches If-Match, or has the version of the body if the header is missing
+//   - PATCH without an updateMask in the body updates the order fields present in the body
+func (r RestServer) hookOrderService() {
+	withETag := func(c *gin.Context, resp protobuf.Message) int {
+		c.Header("ETag", etag(orderOf(resp)))
+		return http.StatusOK
+	}
+	ifMatch := map[string]string{"If-Match": "ETags of the order versions the change may apply to, or * for any, instead of expectedVersion"}
+	versionStatuses := map[int]string{
+		http.StatusPreconditionFailed:   "The order does not match If-Match or expectedVersion",
+		http.StatusPreconditionRequired: "Neither If-Match nor expectedVersion is set",
+	}
+	idempotencyKey := map[string]string{IdempotencyKeyHeader: "Key making retries of the request return the first response"}
+
+	r.gateway.hook(proto.OrderService_Create_FullMethodName, gatewayHook{
+		request: func(c *gin.Context, req protobuf.Message, body []byte) bool {
+			if strings.Contains(c.GetHeader("Prefer"), "respond-async") {
+				r.gateway.call(c, proto.OrderService_CreateAsync_FullMethodName, req)
+				return false
+			}
+			return true
+		},
+		headers: map[string]string{
+			IdempotencyKeyHeader: idempotencyKey[IdempotencyKeyHeader],
+			"Prefer":             "respond-async creates the order in the background",
+		},
+		statuses: map[int]string{http.StatusAccepted: "With Prefer: respond-async, the operation creating the order, whose location is in Location"},
+	})
+	r.gateway.hook(proto.OrderService_CreateAsync_FullMethodName, gatewayHook{
+		response: func(c *gin.Context, resp protobuf.Message) int {
+			c.Header("Location", "/v1/"+resp.(*longrunningpb.Operation).Name)
+			return http.StatusAccepted
+		},
+		headers: idempotencyKey,
+		status:  http.StatusAccepted,
+	})
+	r.gateway.hook(proto.OrderService_Retrieve_FullMethodName, gatewayHook{
+		response: func(c *gin.Context, resp protobuf.Message) int {
+			tag := etag(orderOf(resp))
+			c.Header("ETag", tag)
+			if ifNoneMatch(c.GetHeader("If-None-Match"), tag) {
+				return http.StatusNotModified
+			}
+			return http.StatusOK
+		},
+		headers:  map[string]string{"If-None-Match": "ETag of a cached copy of the order"},
+		statuses: map[int]string{http.StatusNotModified: "The order still matches If-None-Match"},
+	})
+	r.gateway.hook(proto.OrderService_Update_FullMethodName, gatewayHook{
+		request: func(c *gin.Context, req protobuf.Message, body []byte) bool {
+			update := req.(*proto.UpdateOrderRequest)
+			if c.Request.Method == http.MethodPatch && update.UpdateMask == nil {
+				if update.UpdateMask = fieldMaskOfUpdate(update); len(update.UpdateMask.Paths) == 0 {
+					writeError(c, status.Error(codes.InvalidArgument, "the body sets none of the fields to update, items and paymentMethod"))
+					return false
+				}
+			}
+			return expectedVersion(c, &update.ExpectedVersion)
+		},
+		response: withETag,
+		headers:  map[string]string{"If-Match": ifMatch["If-Match"], IdempotencyKeyHeader: idempotencyKey[IdempotencyKeyHeader]},
+		statuses: versionStatuses,
+	})
+	r.gateway.hook(proto.OrderService_UpdateStatus_FullMethodName, gatewayHook{
+		request: func(c *gin.Context, req protobuf.Message, body []byte) bool {
+			return expectedVersion(c, &req.(*proto.UpdateOrderStatusRequest).ExpectedVersion)
+		},
+		response: withETag,
+		headers:  ifMatch,
+		statuses: versionStatuses,
+	})
+	r.gateway.hook(proto.OrderService_Delete_FullMethodName, gatewayHook{
+		request: func(c *gin.Context, req protobuf.Message, body []byte) bool {
+			return expectedVersion(c, &req.(*proto.DeleteOrderRequest).ExpectedVersion)
+		},
+		response:     withETag,
+		queryAliases: map[string]string{"id": "order_id"}, // DELETE /order?id=N of the first REST API
+		headers:      ifMatch,
+		statuses:     versionStatuses,
+	})
+}
+
+// orderOf returns the order of a response of the order service
+func orderOf(resp protobuf.Message) *proto.Order {
+	if r, ok := resp.(interface{ GetOrder() *proto.Order }); ok {
+		return r.GetOrder()
+	}
+	return nil
+}
+
 /*
 // Start launches the server
 func (r RestServer) Start() error {
@@ -52,13 +242,25 @@ func (r RestServer) Start() error {
 // Optimization. Start launches the REST server in the background, sending errors to the error channel
 func (r RestServer) Start() {
 	go func() {
-		r.errCh <- r.server.ListenAndServe()
+		if r.server.TLSConfig != nil {
+			// The certificate comes from the TLS configuration
+			r.errCh <- r.server.ServeTLS(r.listener, "", "")
+			return
+		}
+		r.errCh <- r.server.Serve(r.listener)
 	}()
 }
 
-// Optimization. Stop stops the server
+// Optimization. Stop ends the event streams, stops accepting requests and waits for the active ones
+// to finish, for at most restShutdownTimeout, before closing the remaining connections
 func (r RestServer) Stop() error {
-	return r.server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), restShutdownTimeout)
+	defer cancel()
+	r.events.close()
+	if err := r.server.Shutdown(ctx); err != nil {
+		return r.server.Close()
+	}
+	return nil
 }
 
 // Optimization. Error returns the server's error channel
@@ -66,58 +268,168 @@ func (r RestServer) Error() chan error {
 	return r.errCh
 }
 
-// The create handler function creates an order from the request (JSON body)
-func (r RestServer) create(c *gin.Context) {
-	var req proto.CreateOrderRequest
+// SetBodyDecoding changes how request bodies are decoded. The maximum size also applies to Connect messages
+func (r RestServer) SetBodyDecoding(decoding BodyDecoding) {
+	r.gateway.SetBodyDecoding(decoding)
+	r.connect.setReadMaxBytes(decoding.MaxSize)
+}
+
+// SetWebSocketOrigins sets the host patterns of the other origins whose pages may open WebSocket
+// connections to the order feed. Pages of the host of the service always may
+func (r RestServer) SetWebSocketOrigins(origins []string) {
+	r.events.origins.Store(&origins)
+}
+
+// httpStatusFromError maps the gRPC status code of an order service error to an HTTP status
+func httpStatusFromError(err error) int {
+	switch status.Code(err) {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
 
-	/*
-		In this version, io.ReadAll is used to read the entire content
-		of c.Request.Body into a []byte, which can then be passed to protojson.Unmarshal.
-	*/
+// etag returns the entity tag of an order, derived from its version
+func etag(order *proto.Order) string {
+	return `"` + strconv.FormatInt(order.GetVersion(), 10) + `"`
+}
 
-	// Read the content of the request body into a []byte
-	body, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "error reading request body")
-		return
+// ifNoneMatch tells whether an If-None-Match header value matches the entity tag of a representation,
+// as RFC 9110 defines it: * matches any representation, and otherwise one of the entity tags of the list
+// must match with the weak comparison, which ignores the W/ prefix. A malformed value matches nothing
+func ifNoneMatch(header, tag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
 	}
+	tags, ok := entityTags(header)
+	if !ok {
+		return false
+	}
+	for _, t := range tags {
+		if strings.TrimPrefix(t, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
 
-	// Request deserialization
-	// err := protojson.Unmarshal(c.Request.Body, &req)
-	err = protojson.Unmarshal(body, &req)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "error creating order request")
-		return
+// entityTags splits a list of entity tags, keeping their W/ prefix. ok is false if the list is malformed
+func entityTags(header string) (tags []string, ok bool) {
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			return tags, true
+		}
+		// Entity tags may contain commas, so the list is split at the end of each quoted tag
+		weak := strings.HasPrefix(header, "W/")
+		opaque := strings.TrimPrefix(header, "W/")
+		if !strings.HasPrefix(opaque, `"`) {
+			return nil, false
+		}
+		end := strings.IndexByte(opaque[1:], '"') + 2
+		if end < 2 {
+			return nil, false
+		}
+		tag := opaque[:end]
+		if weak {
+			tag = "W/" + tag
+		}
+		tags = append(tags, tag)
+		header = opaque[end:]
 	}
+}
 
-	// Uses the order service to create an order from the request
-	resp, err := r.orderService.Create(c.Request.Context(), &req)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "error creating order")
-		return
+// parseIfMatch parses an If-Match header value as RFC 9110 defines it: * matches any existing order,
+// and otherwise one of the entity tags of the list must match with the strong comparison,
+// so weak tags match nothing. ok is false if the value is malformed
+func parseIfMatch(header string) (cond versionCondition, ok bool) {
+	if strings.TrimSpace(header) == "*" {
+		return versionCondition{any: true}, true
 	}
-	// Marshal the response to JSON
-	//m := &protojsonpb.MarshalOptions{}
-	m := &protojson.MarshalOptions{}
-	if data, err := m.Marshal(resp); err != nil {
-		c.String(http.StatusInternalServerError, "error sending order response")
-	} else {
-		c.Data(http.StatusOK, "application/json", data)
+	tags, ok := entityTags(header)
+	if !ok || len(tags) == 0 {
+		return versionCondition{}, false
 	}
+	for _, tag := range tags {
+		if v, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64); err == nil && !strings.HasPrefix(tag, "W/") {
+			cond.versions = append(cond.versions, v)
+		}
+	}
+	return cond, true
 }
 
-func (r RestServer) retrieve(c *gin.Context) {
-	c.String(http.StatusNotImplemented, "not implemented yet")
+// expectedVersion passes the condition of the If-Match header to the RPC, which checks it against
+// the current version of the order. Without the header, it responds with 428 Precondition Required
+// and returns false if the body sets no version either
+func expectedVersion(c *gin.Context, version *int64) bool {
+	if match := c.GetHeader("If-Match"); match != "" {
+		cond, ok := parseIfMatch(match)
+		if !ok {
+			writeError(c, status.Error(codes.InvalidArgument, "If-Match is not * or a list of entity tags"))
+			return false
+		}
+		c.Request = c.Request.WithContext(withVersionCondition(c.Request.Context(), cond))
+		return true
+	}
+	if *version == 0 {
+		writeStatus(c, http.StatusPreconditionRequired, status.New(codes.FailedPrecondition, "If-Match header with the order ETag is required"))
+		return false
+	}
+	return true
 }
 
-func (r RestServer) update(c *gin.Context) {
-	c.String(http.StatusNotImplemented, "not implemented yet")
+// writeError responds with the HTTP status of an error and its message. A version mismatch is reported
+// as 412 Precondition Failed, and the retry delay of a rejected call in Retry-After
+func writeError(c *gin.Context, err error) {
+	code := httpStatusFromError(err)
+	if errors.Is(err, ErrVersionMismatch) {
+		code = http.StatusPreconditionFailed
+	}
+	if delay, ok := retryDelay(err); ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+	writeStatus(c, code, status.Convert(err))
 }
 
-func (r RestServer) delete(c *gin.Context) {
-	c.String(http.StatusNotImplemented, "not implemented yet")
+// writeStatus responds with the HTTP status code and the error status, encoded in the negotiated media type
+func writeStatus(c *gin.Context, code int, st *status.Status) {
+	codec := codecOf(c)
+	data, err := codec.marshalError(st)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error sending error response")
+		return
+	}
+	c.Data(code, codec.mediaType, data)
 }
 
-func (r RestServer) list(c *gin.Context) {
-	c.String(http.StatusNotImplemented, "not implemented yet")
+// writeProto responds with the status code and the message, encoded in the negotiated media type
+func writeProto(c *gin.Context, code int, msg protobuf.Message) {
+	codec := codecOf(c)
+	data, err := codec.marshal(msg)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error sending order response")
+		return
+	}
+	c.Data(code, codec.mediaType, data)
 }
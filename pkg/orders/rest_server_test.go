@@ -0,0 +1,104 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+)
+
+func TestIfNoneMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"3"`, true},
+		{`W/"3"`, true},
+		{`"2"`, false},
+		{`"1", "3"`, true},
+		{`"1",W/"3" `, true},
+		{`"1", "2"`, false},
+		{`"a,b", "3"`, true},
+		{`*`, true},
+		{` * `, true},
+		{``, false},
+		{`3`, false},
+		{`"3`, false},
+		{`"1", 3`, false},
+		{`"3", 3`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := ifNoneMatch(tt.header, `"3"`); got != tt.want {
+				t.Errorf("ifNoneMatch(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	order := &proto.Order{OrderId: 1, Version: 3}
+	tests := []struct {
+		header string
+		ok     bool // Whether the header is well formed
+		want   bool
+	}{
+		{`"3"`, true, true},
+		{`W/"3"`, true, false},
+		{`"2"`, true, false},
+		{`"1", "3"`, true, true},
+		{`"1",W/"3" `, true, false},
+		{`W/"1", "3"`, true, true},
+		{`"1", "2"`, true, false},
+		{`"a,b", "3"`, true, true},
+		{`"a"`, true, false},
+		{`*`, true, true},
+		{` * `, true, true},
+		{`3`, false, false},
+		{`"3`, false, false},
+		{`"3", 3`, false, false},
+		{` , `, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			cond, ok := parseIfMatch(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("parseIfMatch(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			version, err := expectedVersionOf(withVersionCondition(context.Background(), cond), 0, order)
+			if got := err == nil; got != tt.want {
+				t.Fatalf("expectedVersionOf(If-Match: %s) error = %v, want match %v", tt.header, err, tt.want)
+			}
+			if err == nil && version != order.Version {
+				t.Errorf("expectedVersionOf(If-Match: %s) = %d, want %d", tt.header, version, order.Version)
+			} else if err != nil && !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("expectedVersionOf(If-Match: %s) error = %v, want %v", tt.header, err, ErrVersionMismatch)
+			}
+		})
+	}
+}
+
+func TestExpectedVersionOf(t *testing.T) {
+	order := &proto.Order{OrderId: 1, Version: 3}
+	ctx := context.Background()
+	if err := checkExpectedVersion(ctx, 0); !errors.Is(err, ErrExpectedVersionRequired) {
+		t.Errorf("checkExpectedVersion() without a version error = %v, want %v", err, ErrExpectedVersionRequired)
+	}
+	if err := checkExpectedVersion(withVersionCondition(ctx, versionCondition{any: true}), 0); err != nil {
+		t.Errorf("checkExpectedVersion() with If-Match error = %v", err)
+	}
+	if v, err := expectedVersionOf(ctx, 3, order); err != nil || v != 3 {
+		t.Errorf("expectedVersionOf(3) = %d, %v, want 3", v, err)
+	}
+	if _, err := expectedVersionOf(ctx, 2, order); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expectedVersionOf(2) error = %v, want %v", err, ErrVersionMismatch)
+	}
+	// If-Match takes precedence over the version of the body
+	if v, err := expectedVersionOf(withVersionCondition(ctx, versionCondition{versions: []int64{3}}), 2, order); err != nil || v != 3 {
+		t.Errorf("expectedVersionOf(2, If-Match: \"3\") = %d, %v, want 3", v, err)
+	}
+}
@@ -0,0 +1,381 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	ErrNoItems                 = status.Error(codes.InvalidArgument, "an order must contain at least one item")
+	ErrExpectedVersionRequired = status.Error(codes.InvalidArgument, "expected_version is required")
+	ErrOrderNotModifiable      = status.Error(codes.FailedPrecondition, "order can no longer be modified")
+)
+
+// OrderService implements the order service on top of an OrderStore
+type OrderService struct {
+	proto.UnimplementedOrderServiceServer
+	store      *OrderStore
+	operations *Operations
+	dispatcher OrderDispatcher
+	pricing    Pricing
+	// validationTimeout bounds the payment and inventory checks of an order, 0 means no bound.
+	// It and the features can change while the service runs
+	validationTimeout atomic.Int64
+	features          atomic.Pointer[Features]
+}
+
+// NewOrderService creates an OrderService saving orders to the store, running
+// asynchronous creations as operations and submitting new orders to the dispatcher.
+// Orders are priced with pricing and validated within validationTimeout, if not 0
+func NewOrderService(store *OrderStore, operations *Operations, dispatcher OrderDispatcher, pricing Pricing, validationTimeout time.Duration, features Features) *OrderService {
+	s := &OrderService{
+		store:      store,
+		operations: operations,
+		dispatcher: dispatcher,
+		pricing:    pricing,
+	}
+	s.SetValidationTimeout(validationTimeout)
+	s.SetFeatures(features)
+	return s
+}
+
+// SetValidationTimeout changes the bound of the payment and inventory checks, 0 means no bound
+func (s *OrderService) SetValidationTimeout(timeout time.Duration) {
+	s.validationTimeout.Store(int64(timeout))
+}
+
+// SetFeatures changes the optional features of the service
+func (s *OrderService) SetFeatures(features Features) {
+	s.features.Store(&features)
+}
+
+// validate runs validateOrder within the validation timeout and converts its error to a gRPC status error.
+// Create, Update and QuoteOrder share it, so that a quote finds the problems Create would.
+// It returns whether each item is in stock, nil if the inventory check did not complete
+func (s *OrderService) validate(ctx context.Context, items []*proto.Item, payment *proto.PaymentMethod, amount float32, authorize bool) ([]bool, error) {
+	if timeout := time.Duration(s.validationTimeout.Load()); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	inStock, err := validateOrder(ctx, items, payment, amount, authorize)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			// The checks report their interruption as a timeout, whatever its cause
+			return inStock, status.Error(codes.Canceled, "order validation was cancelled")
+		}
+		return inStock, validationStatus(err)
+	}
+	return inStock, nil
+}
+
+// Create validates the order's payment and inventory and saves it
+func (s *OrderService) Create(ctx context.Context, req *proto.CreateOrderRequest) (*proto.CreateOrderResponse, error) {
+	if err := checkItems(req.Items); err != nil {
+		return nil, err
+	}
+	price := s.pricing.price(req.Items)
+	if _, err := s.validate(ctx, req.Items, req.PaymentMethod, price.total, true); err != nil {
+		return nil, err
+	}
+
+	// The order is only saved once the dispatcher admitted it, so that a rejected order can be retried
+	submit, err := s.dispatcher.Reserve()
+	if err != nil {
+		slog.ErrorContext(ctx, "order not dispatched", "error", err)
+		return nil, err
+	}
+	id, _ := IdentityFromContext(ctx)
+	order := s.store.Create(&proto.Order{
+		CustomerId:    id.Subject,
+		Items:         req.Items,
+		Total:         price.total,
+		OrderDate:     timestamppb.Now(),
+		Status:        proto.Order_PENDING,
+		PaymentMethod: storedPaymentMethod(req.PaymentMethod),
+	})
+	slog.InfoContext(ctx, "order created", "order_id", order.OrderId, "customer_id", order.CustomerId, "total", order.Total)
+	submit(ctx, order)
+
+	return &proto.CreateOrderResponse{Order: order}, nil
+}
+
+// storedPaymentMethod returns the payment method saved with an order: its type without the
+// pre-authorization token, which is a payment credential and must not be returned by the read APIs
+func storedPaymentMethod(payment *proto.PaymentMethod) *proto.PaymentMethod {
+	if payment == nil {
+		return nil
+	}
+	return &proto.PaymentMethod{PaymentType: payment.PaymentType}
+}
+
+// CreateAsync starts creating an order in the background and returns the pending operation.
+// The operation's response is a CreateOrderResponse
+func (s *OrderService) CreateAsync(ctx context.Context, req *proto.CreateOrderRequest) (*longrunningpb.Operation, error) {
+	if !s.features.Load().AsyncCreate {
+		return nil, errFeatureDisabled("CreateAsync")
+	}
+	if err := checkItems(req.Items); err != nil {
+		return nil, err
+	}
+
+	metadata := &proto.CreateOrderMetadata{CreateTime: timestamppb.Now()}
+	return s.operations.Start(ctx, metadata, func(ctx context.Context) (protobuf.Message, error) {
+		return s.Create(ctx, req)
+	})
+}
+
+// QuoteOrder prices the order like Create and reports the problems the checks of Create find with it,
+// without authorizing payment, reserving stock or saving anything
+func (s *OrderService) QuoteOrder(ctx context.Context, req *proto.QuoteOrderRequest) (*proto.QuoteOrderResponse, error) {
+	if !s.features.Load().QuoteOrder {
+		return nil, errFeatureDisabled("QuoteOrder")
+	}
+	price := s.pricing.price(req.Items)
+	// Items out of stock, which validate reports as FailedPrecondition, are problems of the quote, not errors
+	inStock, err := s.validate(ctx, req.Items, req.PaymentMethod, price.total, false)
+	if err != nil && status.Code(err) != codes.FailedPrecondition {
+		return nil, err
+	}
+
+	resp := &proto.QuoteOrderResponse{
+		Subtotal: price.subtotal,
+		Tax:      price.tax,
+		Shipping: price.shipping,
+		Total:    price.total,
+		Valid:    true,
+	}
+	if len(req.Items) == 0 {
+		resp.Problems = append(resp.Problems, status.Convert(ErrNoItems).Message())
+		resp.Valid = false
+	}
+	for i, item := range req.Items {
+		line := &proto.QuoteLine{
+			Item:     item,
+			Tax:      price.itemTax[i],
+			InStock:  inStock[i],
+			Problems: itemProblems(item),
+		}
+		if !line.InStock {
+			line.Problems = append(line.Problems, "item is out of stock")
+		}
+		if len(line.Problems) > 0 {
+			resp.Valid = false
+		}
+		resp.Lines = append(resp.Lines, line)
+	}
+
+	return resp, nil
+}
+
+// Retrieve returns an existing order
+func (s *OrderService) Retrieve(ctx context.Context, req *proto.RetrieveOrderRequest) (*proto.RetrieveOrderResponse, error) {
+	order, err := s.store.Get(req.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOwner(ctx, order); err != nil {
+		return nil, err
+	}
+	return &proto.RetrieveOrderResponse{Order: order}, nil
+}
+
+// Update changes the items and payment method of a pending order if it still has the expected version.
+// Only the fields in the update mask are changed when one is given
+func (s *OrderService) Update(ctx context.Context, req *proto.UpdateOrderRequest) (*proto.UpdateOrderResponse, error) {
+	if err := checkExpectedVersion(ctx, req.ExpectedVersion); err != nil {
+		return nil, err
+	}
+
+	current, err := s.ownedOrder(ctx, req.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := expectedVersionOf(ctx, req.ExpectedVersion, current)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergeUpdate(current, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkItems(merged.Items); err != nil {
+		return nil, err
+	}
+	price := s.pricing.price(merged.Items)
+	// A kept payment method was authorized when it was set, and its token is not stored to authorize it again
+	if _, err := s.validate(ctx, merged.Items, merged.PaymentMethod, price.total, updatesPayment(req)); err != nil {
+		return nil, err
+	}
+
+	// The store checks the version again, so a concurrent change made during validation is not lost
+	order, err := s.store.Update(req.OrderId, expected, func(order *proto.Order) error {
+		if order.Status != proto.Order_PENDING {
+			return ErrOrderNotModifiable
+		}
+		order.Items = merged.Items
+		order.PaymentMethod = storedPaymentMethod(merged.PaymentMethod)
+		order.Total = price.total
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &proto.UpdateOrderResponse{Order: order}, nil
+}
+
+// nextStatuses lists the fulfillment statuses an order can move to from each status.
+// Orders are cancelled through Delete
+var nextStatuses = map[proto.Order_Status][]proto.Order_Status{
+	proto.Order_PENDING: {proto.Order_PAID},
+	proto.Order_PAID:    {proto.Order_SHIPPED},
+	proto.Order_SHIPPED: {proto.Order_DELIVERED},
+}
+
+// UpdateStatus moves an order to the next fulfillment status if it still has the expected version
+func (s *OrderService) UpdateStatus(ctx context.Context, req *proto.UpdateOrderStatusRequest) (*proto.UpdateOrderStatusResponse, error) {
+	if err := checkExpectedVersion(ctx, req.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	if err := checkStatusAllowed(ctx, req.Status); err != nil {
+		return nil, err
+	}
+	current, err := s.ownedOrder(ctx, req.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := expectedVersionOf(ctx, req.ExpectedVersion, current)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := s.store.Update(req.OrderId, expected, func(order *proto.Order) error {
+		for _, next := range nextStatuses[order.Status] {
+			if next == req.Status {
+				order.Status = next
+				return nil
+			}
+		}
+		return status.Errorf(codes.FailedPrecondition, "order status cannot change from %s to %s", order.Status, req.Status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &proto.UpdateOrderStatusResponse{Order: order}, nil
+}
+
+// Delete cancels an order if it still has the expected version
+func (s *OrderService) Delete(ctx context.Context, req *proto.DeleteOrderRequest) (*proto.DeleteOrderResponse, error) {
+	if err := checkExpectedVersion(ctx, req.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	current, err := s.ownedOrder(ctx, req.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := expectedVersionOf(ctx, req.ExpectedVersion, current)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := s.store.Update(req.OrderId, expected, func(order *proto.Order) error {
+		if order.Status != proto.Order_PENDING && order.Status != proto.Order_PAID {
+			return ErrOrderNotModifiable
+		}
+		order.Status = proto.Order_CANCELLED
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &proto.DeleteOrderResponse{Order: order}, nil
+}
+
+// List returns the orders with the requested ids (all orders if none are given).
+// Since the status filter is a single enum value, the zero value PENDING means no filter
+func (s *OrderService) List(ctx context.Context, req *proto.ListOrderRequest) (*proto.ListOrderResponse, error) {
+	var orders []*proto.Order
+	for _, order := range s.store.List(req.Ids) {
+		if !ownsOrder(ctx, order) {
+			continue
+		}
+		if req.Statuses == proto.Order_PENDING || order.Status == req.Statuses {
+			orders = append(orders, order)
+		}
+	}
+	return &proto.ListOrderResponse{Orders: orders}, nil
+}
+
+// ownedOrder returns the order with the given id. It is hidden from a caller limited to its own orders,
+// if the caller did not create it
+func (s *OrderService) ownedOrder(ctx context.Context, id int64) (*proto.Order, error) {
+	order, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOwner(ctx, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// versionCondition is the condition of an If-Match header on the version of an order
+type versionCondition struct {
+	any      bool    // * matches any version
+	versions []int64 // Versions of the strong entity tags of the list
+}
+
+type versionConditionCtx struct{}
+
+// withVersionCondition returns a copy of ctx carrying the If-Match condition of the request
+func withVersionCondition(ctx context.Context, cond versionCondition) context.Context {
+	return context.WithValue(ctx, versionConditionCtx{}, cond)
+}
+
+// checkExpectedVersion fails if a change sets neither an expected version nor an If-Match condition
+func checkExpectedVersion(ctx context.Context, expected int64) error {
+	if _, ok := ctx.Value(versionConditionCtx{}).(versionCondition); !ok && expected == 0 {
+		return ErrExpectedVersionRequired
+	}
+	return nil
+}
+
+// expectedVersionOf returns the version a change of order expects: the version of order if it meets
+// the If-Match condition of ctx, or else the expected version of the request
+func expectedVersionOf(ctx context.Context, expected int64, order *proto.Order) (int64, error) {
+	if cond, ok := ctx.Value(versionConditionCtx{}).(versionCondition); ok {
+		if !cond.any && !slices.Contains(cond.versions, order.Version) {
+			return 0, ErrVersionMismatch
+		}
+		return order.Version, nil
+	}
+	if expected != order.Version {
+		return 0, ErrVersionMismatch
+	}
+	return expected, nil
+}
+
+// validationStatus converts an error from validateOrder to a gRPC status error
+func validationStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrPreAuthorizationTimeout), errors.Is(err, ErrInventoryRequestTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, ErrItemOutOfStock):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,129 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrOrderNotFound   = status.Error(codes.NotFound, "order not found")
+	ErrVersionMismatch = status.Error(codes.Aborted, "order was modified by another request, version mismatch")
+)
+
+// OrderStore keeps orders in memory. Orders are cloned on the way in and out,
+// so callers never share an order with the store. Every change is published to its change feed
+type OrderStore struct {
+	mu      sync.RWMutex
+	orders  map[int64]*proto.Order
+	lastID  int64
+	changes *ChangeFeed
+}
+
+// NewOrderStore creates an empty OrderStore
+func NewOrderStore() *OrderStore {
+	return &OrderStore{orders: make(map[int64]*proto.Order), changes: newChangeFeed()}
+}
+
+// Changes returns the feed of the changes of the orders
+func (s *OrderStore) Changes() *ChangeFeed {
+	return s.changes
+}
+
+// Ping checks that the store can serve reads, failing if a writer holds it past the deadline of ctx
+func (s *OrderStore) Ping(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.mu.RLock()
+		s.mu.RUnlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("order store is not responding")
+	}
+}
+
+// Create assigns an id and the first version to the order and saves it
+func (s *OrderStore) Create(order *proto.Order) *proto.Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	saved := protobuf.Clone(order).(*proto.Order)
+	saved.OrderId = s.lastID
+	saved.Version = 1
+	s.orders[saved.OrderId] = saved
+	// Saved orders are replaced, never modified, so the feed can share them
+	s.changes.publish(saved, nil)
+
+	return protobuf.Clone(saved).(*proto.Order)
+}
+
+// Get returns the order with the given id
+func (s *OrderStore) Get(id int64) (*proto.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	return protobuf.Clone(order).(*proto.Order), nil
+}
+
+// Update applies fn to the order with the given id if its version equals expectedVersion,
+// then saves it under the next version. Nothing is saved if fn returns an error
+func (s *OrderStore) Update(id, expectedVersion int64, fn func(order *proto.Order) error) (*proto.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if current.Version != expectedVersion {
+		return nil, ErrVersionMismatch
+	}
+
+	updated := protobuf.Clone(current).(*proto.Order)
+	if err := fn(updated); err != nil {
+		return nil, err
+	}
+	updated.OrderId = id
+	updated.Version = current.Version + 1
+	s.orders[id] = updated
+	s.changes.publish(updated, current)
+
+	return protobuf.Clone(updated).(*proto.Order), nil
+}
+
+// List returns the orders with the given ids, or all orders if no ids are given
+func (s *OrderStore) List(ids []int64) []*proto.Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []*proto.Order
+	if len(ids) == 0 {
+		for id := int64(1); id <= s.lastID; id++ {
+			if order, ok := s.orders[id]; ok {
+				orders = append(orders, protobuf.Clone(order).(*proto.Order))
+			}
+		}
+		return orders
+	}
+	for _, id := range ids {
+		if order, ok := s.orders[id]; ok {
+			orders = append(orders, protobuf.Clone(order).(*proto.Order))
+		}
+	}
+	return orders
+}
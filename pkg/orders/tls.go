@@ -0,0 +1,130 @@
+package orders
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsCheckInterval is how often the certificate files are checked for changes
+const tlsCheckInterval = 10 * time.Second
+
+// TLSReloader serves a certificate and, for mutual TLS, a client CA bundle from files.
+// The files are reloaded when they change on disk, so certificates can be renewed without a restart
+type TLSReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string // Empty when client certificates are not verified
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// NewTLSReloader creates a TLSReloader for the certificate and key files. If clientCAFile is
+// not empty, clients must present a certificate signed by one of the CAs in that file
+func NewTLSReloader(certFile, keyFile, clientCAFile string) (*TLSReloader, error) {
+	r := &TLSReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files returns the files the TLSReloader reads
+func (r *TLSReloader) files() []string {
+	if r.clientCAFile == "" {
+		return []string{r.certFile, r.keyFile}
+	}
+	return []string{r.certFile, r.keyFile, r.clientCAFile}
+}
+
+// load reads the certificate, the key and the client CA bundle
+func (r *TLSReloader) load() error {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: %w", r.clientCAFile, errors.New("no CA certificates found"))
+		}
+	}
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+// current returns the certificate and client CAs, reloading them first if the files changed.
+// A failed reload keeps the previous certificate, as the files may be in the middle of an update
+func (r *TLSReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < tlsCheckInterval {
+		return r.cert, r.clientCAs
+	}
+	r.lastCheck = time.Now()
+
+	for i, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(r.modTimes[i]) {
+			if err := r.load(); err != nil {
+				slog.Error("failed to reload TLS certificate, keeping the current one", "error", err)
+			}
+			break
+		}
+	}
+	return r.cert, r.clientCAs
+}
+
+// Config returns a TLS configuration using the current certificate and client CAs
+// for every handshake. nextProtos are the ALPN protocols of the listener
+func (r *TLSReloader) Config(nextProtos ...string) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, clientCAs := r.current()
+		handshake := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   nextProtos,
+			Certificates: []tls.Certificate{*cert},
+		}
+		if clientCAs != nil {
+			handshake.ClientCAs = clientCAs
+			handshake.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return handshake, nil
+	}
+	return config
+}
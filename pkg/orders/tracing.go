@@ -0,0 +1,122 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// serviceName names the order service in traces
+	serviceName = "orders"
+	// tracerName is the instrumentation scope of the order service spans
+	tracerName = "github.com/AndreiMartynenko/grpc-eshop/pkg/orders"
+)
+
+// tracer creates the order service spans. It uses the global tracer provider set up by SetupTracing
+var tracer = otel.Tracer(tracerName)
+
+// SetupTracing installs the global tracer provider and the W3C trace context propagator.
+// Spans are exported to the OTLP gRPC endpoint if otlpEndpoint is not empty (see parseOTLPEndpoint),
+// otherwise to the file as JSON if file is not empty, otherwise they are not recorded.
+// The returned function flushes the spans and stops the exporter
+func SetupTracing(ctx context.Context, otlpEndpoint, file string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch {
+	case otlpEndpoint != "":
+		address, insecure, err := parseOTLPEndpoint(otlpEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(address)}
+		if insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		// The other OTEL_EXPORTER_OTLP_* variables, such as the certificate and headers, are read by the exporter
+		e, err := otlptracegrpc.New(ctx, options...)
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	case file != "":
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter = fileExporter{SpanExporter: e, file: f}
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// parseOTLPEndpoint returns the host and port of an OTLP endpoint and whether it is reached without TLS.
+// The endpoint is a URL, http://collector:4317 without TLS or https://collector:4317 with TLS,
+// or a host and port, reached with TLS unless OTEL_EXPORTER_OTLP_INSECURE is true, as the exporter reads it
+func parseOTLPEndpoint(endpoint string) (address string, insecure bool, err error) {
+	if !strings.Contains(endpoint, "://") {
+		return endpoint, false, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, fmt.Errorf("OTLP endpoint: %w", err)
+	}
+	switch {
+	case u.Host == "":
+		return "", false, fmt.Errorf("OTLP endpoint %q: no host", endpoint)
+	case u.Scheme == "http":
+		return u.Host, true, nil
+	case u.Scheme == "https":
+		return u.Host, false, nil
+	}
+	return "", false, fmt.Errorf("OTLP endpoint %q: the scheme must be http or https", endpoint)
+}
+
+// fileExporter is a span exporter writing to a file, which it closes on shutdown
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+// Shutdown stops the exporter and closes the file
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// endSpan records err, if any, on the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,30 @@
+package orders
+
+import "testing"
+
+func TestParseOTLPEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		address  string
+		insecure bool
+		wantErr  bool
+	}{
+		{endpoint: "collector:4317", address: "collector:4317"},
+		{endpoint: "http://collector:4317", address: "collector:4317", insecure: true},
+		{endpoint: "https://collector:4317", address: "collector:4317"},
+		{endpoint: "https://collector:4317/", address: "collector:4317"},
+		{endpoint: "grpc://collector:4317", wantErr: true},
+		{endpoint: "http://", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			address, insecure, err := parseOTLPEndpoint(tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOTLPEndpoint() error = %v, want error %v", err, tt.wantErr)
+			}
+			if address != tt.address || insecure != tt.insecure {
+				t.Errorf("parseOTLPEndpoint() = %q, %v, want %q, %v", address, insecure, tt.address, tt.insecure)
+			}
+		})
+	}
+}
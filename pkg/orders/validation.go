@@ -3,10 +3,17 @@ package orders
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -30,21 +37,63 @@ func preAuthorizePayment(ctx context.Context, payment *proto.PaymentMethod, orde
 	}
 }
 
-// checkInventory returns a boolean value and an error indicating
-// whether all items are in stock. (true, nil) is returned if
-// all items are in stock, and no errors occurred
-func checkInventory(ctx context.Context, items []*proto.Item) (bool, error) {
+// pingPaymentService checks that the payment service is reachable
+func pingPaymentService(ctx context.Context) error {
+	// The payment service is simulated, so it is always reachable while ctx is alive
+	return ctx.Err()
+}
+
+// pingInventoryService checks that the inventory service is reachable
+func pingInventoryService(ctx context.Context) error {
+	// The inventory service is simulated, so it is always reachable while ctx is alive
+	return ctx.Err()
+}
+
+// checkItemsInStock returns whether each item is in stock, in item order.
+// Nothing is reserved
+func checkItemsInStock(ctx context.Context, items []*proto.Item) ([]bool, error) {
 	// Costly inventory logic is performed here - for this example, we use sleep mode :-)
 	timer := time.NewTimer(2 * time.Second)
 
 	select {
 	case <-timer.C:
-		return true, nil
+		inStock := make([]bool, len(items))
+		for i := range inStock {
+			inStock[i] = true
+		}
+		return inStock, nil
 	case <-ctx.Done():
-		return false, ErrInventoryRequestTimeout
+		return nil, ErrInventoryRequestTimeout
 	}
 }
 
+// itemProblems returns the reasons an item cannot be ordered, stock aside
+func itemProblems(item *proto.Item) []string {
+	var problems []string
+
+	if strings.TrimSpace(item.GetDescription()) == "" {
+		problems = append(problems, "item description is required")
+	}
+	if item.GetPrice() <= 0 {
+		problems = append(problems, "item price must be positive")
+	}
+
+	return problems
+}
+
+// checkItems returns an InvalidArgument error for the first problem with the items, stock aside
+func checkItems(items []*proto.Item) error {
+	if len(items) == 0 {
+		return ErrNoItems
+	}
+	for i, item := range items {
+		if problems := itemProblems(item); len(problems) > 0 {
+			return status.Errorf(codes.InvalidArgument, "item %d: %s", i, problems[0])
+		}
+	}
+	return nil
+}
+
 // getOrderTotal calculates the total order amount
 func getOrderTotal(items []*proto.Item) float32 {
 	var total float32
@@ -56,23 +105,51 @@ func getOrderTotal(items []*proto.Item) float32 {
 	return total
 }
 
-func validateOrder(ctx context.Context, items []*proto.Item, payment *proto.PaymentMethod) error {
+// validateOrder checks that all items are in stock and, if authorize is set, pre-authorizes
+// the payment of the order amount, concurrently. It returns whether each item is in stock,
+// nil if the inventory check did not complete, and ErrItemOutOfStock if one is not
+func validateOrder(ctx context.Context, items []*proto.Item, payment *proto.PaymentMethod, amount float32, authorize bool) (inStock []bool, err error) {
+	ctx, span := tracer.Start(ctx, "validateOrder", trace.WithAttributes(attribute.Int("items", len(items))))
+	defer func() { endSpan(span, err) }()
+
 	g, errCtx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
-		return preAuthorizePayment(errCtx, payment, getOrderTotal(items))
+		if !authorize {
+			return nil
+		}
+		ctx, span := tracer.Start(errCtx, "preAuthorizePayment", trace.WithAttributes(attribute.Float64("amount", float64(amount))))
+		start := time.Now()
+		err := preAuthorizePayment(ctx, payment, amount)
+		observeCheck(paymentCheck, start, err)
+		endSpan(span, err)
+		if err != nil {
+			return err
+		}
+		slog.DebugContext(ctx, "payment pre-authorized", "amount", amount)
+		return nil
 	})
 
 	g.Go(func() error {
-		itemsInStock, err := checkInventory(errCtx, items)
+		ctx, span := tracer.Start(errCtx, "checkInventory")
+		start := time.Now()
+		var err error
+		inStock, err = checkItemsInStock(ctx, items)
+		if err == nil && slices.Contains(inStock, false) {
+			err = ErrItemOutOfStock
+		}
+		observeCheck(inventoryCheck, start, err)
+		endSpan(span, err)
 		if err != nil {
 			return err
 		}
-		if !itemsInStock {
-			return ErrItemOutOfStock
-		}
+		slog.DebugContext(ctx, "inventory checked", "items", len(items))
 		return nil
 	})
 
-	return g.Wait()
+	if err := g.Wait(); err != nil {
+		slog.WarnContext(ctx, "order validation failed", "error", err)
+		return inStock, err
+	}
+	return inStock, nil
 }
@@ -0,0 +1,422 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreiMartynenko/grpc-eshop/proto"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"nhooyr.io/websocket"
+)
+
+const (
+	// wsAuthTimeout bounds how long a connection without credentials in its upgrade request
+	// may take to send its auth message
+	wsAuthTimeout = 10 * time.Second
+	// wsPingInterval is how often the server pings a connection and checks its credentials again,
+	// and wsPongTimeout how long it waits for the pong
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 10 * time.Second
+	// wsWriteTimeout bounds the write of a message to a connection
+	wsWriteTimeout = 10 * time.Second
+	// wsSendBuffer is the number of messages queued for a connection. A client that lets it fill up
+	// is a slow consumer, and is disconnected
+	wsSendBuffer = 256
+	// wsMaxSubscriptions is the number of subscriptions a connection may have at the same time
+	wsMaxSubscriptions = 32
+	// wsReadLimit is the maximum size of a client message
+	wsReadLimit = 64 << 10
+)
+
+// wsClientMessage is a message from a WebSocket client:
+//   - auth authenticates a connection without credentials in its upgrade request, with a bearer token or an API key.
+//     It must be the first message
+//   - subscribe adds a subscription, or replaces the one with the same id, to the changes of the orders
+//     matching all its filters. Empty filters match every order
+//   - unsubscribe removes a subscription
+//   - ack acknowledges the events up to a position. The server only logs the last one when the connection
+//     closes, and does not redeliver unacknowledged events: reconnecting with the query parameter after set
+//     to the position resumes the feed after the events already processed
+type wsClientMessage struct {
+	Type     string   `json:"type"`
+	Token    string   `json:"token,omitempty"`
+	APIKey   string   `json:"apiKey,omitempty"`
+	ID       string   `json:"id,omitempty"`
+	OrderIDs []int64  `json:"orderIds,omitempty"`
+	Statuses []string `json:"statuses,omitempty"`
+	Kinds    []string `json:"kinds,omitempty"` // created, status or updated
+	Seq      int64    `json:"seq,omitempty"`
+}
+
+// wsServerMessage is a message to a WebSocket client:
+//   - ready once the connection is authenticated, with the subject of the caller
+//   - subscribed and unsubscribed in reply to the subscription messages
+//   - event for an order change matching subscriptions of the connection, with its position, kind and order
+//   - reset when the changes after the position the connection resumed from are no longer kept
+//   - error for a message that could not be processed, which does not end the connection
+type wsServerMessage struct {
+	Type          string          `json:"type"`
+	ID            string          `json:"id,omitempty"`
+	Subject       string          `json:"subject,omitempty"`
+	Seq           int64           `json:"seq,omitempty"`
+	Kind          string          `json:"kind,omitempty"`
+	Subscriptions []string        `json:"subscriptions,omitempty"`
+	Order         json.RawMessage `json:"order,omitempty"`
+	Message       string          `json:"message,omitempty"`
+}
+
+// wsSubscription filters the changes sent to a connection
+type wsSubscription struct {
+	orderIDs map[int64]bool
+	statuses map[proto.Order_Status]bool
+	kinds    map[string]bool
+}
+
+// matches tells whether a change passes the filters of the subscription
+func (s wsSubscription) matches(change OrderChange) bool {
+	return (len(s.orderIDs) == 0 || s.orderIDs[change.Order.OrderId]) &&
+		(len(s.statuses) == 0 || s.statuses[change.Order.Status]) &&
+		(len(s.kinds) == 0 || s.kinds[change.Kind()])
+}
+
+// wsClient is a WebSocket connection to the order feed
+type wsClient struct {
+	conn  *websocket.Conn
+	send  chan []byte
+	acked atomic.Int64
+	// The credentials the connection was authenticated with
+	authorization string
+	apiKey        string
+
+	mu            sync.Mutex
+	subscriptions map[string]wsSubscription
+
+	closeOnce   sync.Once
+	closed      chan struct{}
+	closeStatus websocket.StatusCode
+	closeReason string
+}
+
+// close ends the connection with the first status given
+func (cl *wsClient) close(code websocket.StatusCode, reason string) {
+	cl.closeOnce.Do(func() {
+		cl.closeStatus, cl.closeReason = code, reason
+		close(cl.closed)
+	})
+}
+
+// enqueue queues a message for the connection, disconnecting the client if its send buffer is full
+func (cl *wsClient) enqueue(msg wsServerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case cl.send <- data:
+	default:
+		cl.close(websocket.StatusTryAgainLater, "slow consumer")
+	}
+}
+
+// websocket serves the order feed over a WebSocket. A connection receives the changes of the orders
+// the caller may list, filtered by its subscriptions. It is authenticated with the credentials of
+// the upgrade request, or with an auth message for browsers, which cannot set headers on WebSockets.
+// The credentials are checked again at each ping, without counting a request against the limits of an
+// API key, and the connection is closed when they expire or are no longer valid. Pages of other origins
+// may only connect if their host matches the configured origin patterns. The feed starts with the changes after the query parameter after, if set, and the next change otherwise
+func (e eventStreams) websocket(c *gin.Context) {
+	after := e.changes.Last()
+	if v := c.Query("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(c, status.Error(codes.InvalidArgument, "invalid after position"))
+			return
+		}
+	}
+
+	// Credentials in the upgrade request are checked before the upgrade, so that failures are HTTP errors
+	ctx := c.Request.Context()
+	authorization, apiKey := c.GetHeader("Authorization"), c.GetHeader(APIKeyHeader)
+	authenticated := authorization != "" || apiKey != ""
+	if authenticated {
+		var err error
+		if ctx, err = e.authorizeFeed(ctx, authorization, apiKey); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+
+	if !e.addSocket() {
+		writeError(c, status.Error(codes.Unavailable, "the service is shutting down"))
+		return
+	}
+	defer e.sockets.Done()
+
+	var origins []string
+	if p := e.origins.Load(); p != nil {
+		origins = *p
+	}
+	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{OriginPatterns: origins})
+	if err != nil {
+		return // Accept responded with the error
+	}
+	conn.SetReadLimit(wsReadLimit)
+
+	if !authenticated {
+		if ctx, authorization, apiKey, err = e.authenticateConn(ctx, conn); err != nil {
+			conn.Close(websocket.StatusPolicyViolation, status.Convert(err).Message())
+			return
+		}
+	}
+	id, _ := IdentityFromContext(ctx)
+
+	cl := &wsClient{
+		conn:          conn,
+		send:          make(chan []byte, wsSendBuffer),
+		authorization: authorization,
+		apiKey:        apiKey,
+		subscriptions: make(map[string]wsSubscription),
+		closed:        make(chan struct{}),
+	}
+	cl.enqueue(wsServerMessage{Type: "ready", Subject: id.Subject})
+	go e.readClient(cl)
+	go e.feedClient(ctx, cl, after)
+	go e.keepAlive(ctx, cl, id.Expires)
+
+	for done := false; !done; {
+		select {
+		case data := <-cl.send:
+			writeCtx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+			if err := conn.Write(writeCtx, websocket.MessageText, data); err != nil {
+				cl.close(websocket.StatusGoingAway, "write failed")
+			}
+			cancel()
+		case <-cl.closed:
+			done = true
+		case <-e.done:
+			cl.close(websocket.StatusGoingAway, "server stopping")
+		}
+	}
+	if cl.closeStatus == websocket.StatusAbnormalClosure {
+		conn.Close(websocket.StatusNormalClosure, "") // The connection is broken, 1006 cannot be sent
+	} else {
+		conn.Close(cl.closeStatus, cl.closeReason)
+	}
+	slog.InfoContext(ctx, "websocket closed", "subject", id.Subject, "status", cl.closeStatus.String(), "reason", cl.closeReason, "last_ack", cl.acked.Load())
+}
+
+// authorizeFeed authenticates the caller and checks that the caller may list orders,
+// returning the context with the identity and the grant of the caller
+func (e eventStreams) authorizeFeed(ctx context.Context, authorization, apiKey string) (context.Context, error) {
+	ctx, err := e.auth.authenticate(ctx, authorization, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return e.policy.Authorize(ctx, proto.OrderService_List_FullMethodName)
+}
+
+// reauthorizeFeed checks that the credentials of a connection are still valid and may list orders,
+// without counting a request against the limits of an API key
+func (e eventStreams) reauthorizeFeed(ctx context.Context, cl *wsClient) error {
+	ctx, err := e.auth.reauthenticate(ctx, cl.authorization, cl.apiKey)
+	if err != nil {
+		return err
+	}
+	_, err = e.policy.Authorize(ctx, proto.OrderService_List_FullMethodName)
+	return err
+}
+
+// authenticateConn authenticates a connection with its first message, which must be an auth message.
+// It returns the credentials of the message as Authorization header and API key
+func (e eventStreams) authenticateConn(ctx context.Context, conn *websocket.Conn) (context.Context, string, string, error) {
+	readCtx, cancel := context.WithTimeout(ctx, wsAuthTimeout)
+	defer cancel()
+	_, data, err := conn.Read(readCtx)
+	if err != nil {
+		return nil, "", "", status.Error(codes.Unauthenticated, "no auth message")
+	}
+	var msg wsClientMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "auth" {
+		return nil, "", "", status.Error(codes.Unauthenticated, "the first message must be an auth message")
+	}
+	authorization := ""
+	if msg.Token != "" {
+		authorization = "Bearer " + msg.Token
+	}
+	ctx, err = e.authorizeFeed(ctx, authorization, msg.APIKey)
+	return ctx, authorization, msg.APIKey, err
+}
+
+// readClient handles the messages of a client until the connection ends
+func (e eventStreams) readClient(cl *wsClient) {
+	for {
+		// The connection is closed by the writer, which ends the read
+		_, data, err := cl.conn.Read(context.Background())
+		if err != nil {
+			code := websocket.CloseStatus(err)
+			if code == -1 {
+				code = websocket.StatusAbnormalClosure
+			}
+			cl.close(code, "")
+			return
+		}
+
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			cl.enqueue(wsServerMessage{Type: "error", Message: "invalid message: " + err.Error()})
+			continue
+		}
+		if reply, err := cl.handle(msg); err != nil {
+			cl.enqueue(wsServerMessage{Type: "error", ID: msg.ID, Message: err.Error()})
+		} else if reply.Type != "" {
+			cl.enqueue(reply)
+		}
+	}
+}
+
+// handle applies a client message to the connection and returns the reply, if any
+func (cl *wsClient) handle(msg wsClientMessage) (wsServerMessage, error) {
+	switch msg.Type {
+	case "subscribe":
+		if msg.ID == "" {
+			return wsServerMessage{}, errors.New("subscribe: id required")
+		}
+		sub := wsSubscription{
+			orderIDs: make(map[int64]bool),
+			statuses: make(map[proto.Order_Status]bool),
+			kinds:    make(map[string]bool),
+		}
+		for _, id := range msg.OrderIDs {
+			sub.orderIDs[id] = true
+		}
+		for _, name := range msg.Statuses {
+			s, ok := proto.Order_Status_value[name]
+			if !ok {
+				return wsServerMessage{}, errors.New("subscribe: unknown status " + strconv.Quote(name))
+			}
+			sub.statuses[proto.Order_Status(s)] = true
+		}
+		for _, kind := range msg.Kinds {
+			if kind != OrderCreated && kind != OrderStatusChanged && kind != OrderUpdated {
+				return wsServerMessage{}, errors.New("subscribe: unknown kind " + strconv.Quote(kind))
+			}
+			sub.kinds[kind] = true
+		}
+
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		if _, exists := cl.subscriptions[msg.ID]; !exists && len(cl.subscriptions) >= wsMaxSubscriptions {
+			return wsServerMessage{}, errors.New("subscribe: too many subscriptions")
+		}
+		cl.subscriptions[msg.ID] = sub
+		return wsServerMessage{Type: "subscribed", ID: msg.ID}, nil
+	case "unsubscribe":
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		if _, ok := cl.subscriptions[msg.ID]; !ok {
+			return wsServerMessage{}, errors.New("unsubscribe: unknown subscription " + strconv.Quote(msg.ID))
+		}
+		delete(cl.subscriptions, msg.ID)
+		return wsServerMessage{Type: "unsubscribed", ID: msg.ID}, nil
+	case "ack":
+		// Acknowledgements may cross, only the furthest counts. Acks are only stored by the reader
+		if msg.Seq > cl.acked.Load() {
+			cl.acked.Store(msg.Seq)
+		}
+		return wsServerMessage{}, nil
+	case "auth":
+		return wsServerMessage{}, errors.New("auth: already authenticated")
+	default:
+		return wsServerMessage{}, errors.New("unknown message type " + strconv.Quote(msg.Type))
+	}
+}
+
+// matching returns the ids of the subscriptions of the connection a change matches, in no particular order
+func (cl *wsClient) matching(change OrderChange) []string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var ids []string
+	for id, sub := range cl.subscriptions {
+		if sub.matches(change) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// feedClient queues the changes after the position seq that the caller may list and that match
+// subscriptions of the connection, until it ends
+func (e eventStreams) feedClient(ctx context.Context, cl *wsClient, seq int64) {
+	for {
+		changes, next, ok := e.changes.Since(seq)
+		if !ok {
+			seq = e.changes.Last()
+			cl.enqueue(wsServerMessage{Type: "reset", Seq: seq})
+			continue
+		}
+		for _, change := range changes {
+			seq = change.Seq
+			if !ownsOrder(ctx, change.Order) {
+				continue
+			}
+			ids := cl.matching(change)
+			if len(ids) == 0 {
+				continue
+			}
+			order, err := protojson.Marshal(change.Order)
+			if err != nil {
+				continue
+			}
+			cl.enqueue(wsServerMessage{Type: "event", Seq: change.Seq, Kind: change.Kind(), Subscriptions: ids, Order: order})
+		}
+
+		select {
+		case <-next:
+		case <-cl.closed:
+			return
+		}
+	}
+}
+
+// keepAlive pings the connection until it ends, closing it if a pong does not come back in time,
+// and closes it when its credentials expire at expires, or are no longer valid at a ping
+func (e eventStreams) keepAlive(ctx context.Context, cl *wsClient, expires time.Time) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	var expiry <-chan time.Time
+	if !expires.IsZero() {
+		timer := time.NewTimer(time.Until(expires))
+		defer timer.Stop()
+		expiry = timer.C
+	}
+	for {
+		select {
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(context.Background(), wsPongTimeout)
+			err := cl.conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				cl.close(websocket.StatusPolicyViolation, "pong timeout")
+				return
+			}
+			if err := e.reauthorizeFeed(ctx, cl); err != nil {
+				cl.close(websocket.StatusPolicyViolation, status.Convert(err).Message())
+				return
+			}
+		case <-expiry:
+			cl.close(websocket.StatusPolicyViolation, "credentials expired")
+			return
+		case <-cl.closed:
+			return
+		}
+	}
+}
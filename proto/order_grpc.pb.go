@@ -7,6 +7,7 @@
 package proto
 
 import (
+	longrunningpb "cloud.google.com/go/longrunning/autogen/longrunningpb"
 	context "context"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
@@ -19,11 +20,14 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	OrderService_Create_FullMethodName   = "/orders.OrderService/Create"
-	OrderService_Retrieve_FullMethodName = "/orders.OrderService/Retrieve"
-	OrderService_Update_FullMethodName   = "/orders.OrderService/Update"
-	OrderService_Delete_FullMethodName   = "/orders.OrderService/Delete"
-	OrderService_List_FullMethodName     = "/orders.OrderService/List"
+	OrderService_Create_FullMethodName       = "/orders.OrderService/Create"
+	OrderService_CreateAsync_FullMethodName  = "/orders.OrderService/CreateAsync"
+	OrderService_QuoteOrder_FullMethodName   = "/orders.OrderService/QuoteOrder"
+	OrderService_Retrieve_FullMethodName     = "/orders.OrderService/Retrieve"
+	OrderService_Update_FullMethodName       = "/orders.OrderService/Update"
+	OrderService_UpdateStatus_FullMethodName = "/orders.OrderService/UpdateStatus"
+	OrderService_Delete_FullMethodName       = "/orders.OrderService/Delete"
+	OrderService_List_FullMethodName         = "/orders.OrderService/List"
 )
 
 // OrderServiceClient is the client API for OrderService service.
@@ -32,10 +36,19 @@ const (
 type OrderServiceClient interface {
 	// Creates a new order
 	Create(ctx context.Context, in *CreateOrderRequest, opts ...grpc.CallOption) (*CreateOrderResponse, error)
+	// Starts creating a new order and returns right away. The returned operation
+	// can be polled through the google.longrunning.Operations service
+	CreateAsync(ctx context.Context, in *CreateOrderRequest, opts ...grpc.CallOption) (*longrunningpb.Operation, error)
+	// Prices and validates an order without creating it. Payment is not
+	// authorized, stock is not reserved and nothing is saved
+	QuoteOrder(ctx context.Context, in *QuoteOrderRequest, opts ...grpc.CallOption) (*QuoteOrderResponse, error)
 	// Retrieves an existing order
 	Retrieve(ctx context.Context, in *RetrieveOrderRequest, opts ...grpc.CallOption) (*RetrieveOrderResponse, error)
-	// Updates an existing order
+	// Updates an existing order. PATCH without an update mask updates the
+	// order fields present in the body
 	Update(ctx context.Context, in *UpdateOrderRequest, opts ...grpc.CallOption) (*UpdateOrderResponse, error)
+	// Changes the fulfillment status of an existing order
+	UpdateStatus(ctx context.Context, in *UpdateOrderStatusRequest, opts ...grpc.CallOption) (*UpdateOrderStatusResponse, error)
 	// Cancels an existing order
 	Delete(ctx context.Context, in *DeleteOrderRequest, opts ...grpc.CallOption) (*DeleteOrderResponse, error)
 	// Lists current orders
@@ -59,6 +72,24 @@ func (c *orderServiceClient) Create(ctx context.Context, in *CreateOrderRequest,
 	return out, nil
 }
 
+func (c *orderServiceClient) CreateAsync(ctx context.Context, in *CreateOrderRequest, opts ...grpc.CallOption) (*longrunningpb.Operation, error) {
+	out := new(longrunningpb.Operation)
+	err := c.cc.Invoke(ctx, OrderService_CreateAsync_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) QuoteOrder(ctx context.Context, in *QuoteOrderRequest, opts ...grpc.CallOption) (*QuoteOrderResponse, error) {
+	out := new(QuoteOrderResponse)
+	err := c.cc.Invoke(ctx, OrderService_QuoteOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *orderServiceClient) Retrieve(ctx context.Context, in *RetrieveOrderRequest, opts ...grpc.CallOption) (*RetrieveOrderResponse, error) {
 	out := new(RetrieveOrderResponse)
 	err := c.cc.Invoke(ctx, OrderService_Retrieve_FullMethodName, in, out, opts...)
@@ -77,6 +108,15 @@ func (c *orderServiceClient) Update(ctx context.Context, in *UpdateOrderRequest,
 	return out, nil
 }
 
+func (c *orderServiceClient) UpdateStatus(ctx context.Context, in *UpdateOrderStatusRequest, opts ...grpc.CallOption) (*UpdateOrderStatusResponse, error) {
+	out := new(UpdateOrderStatusResponse)
+	err := c.cc.Invoke(ctx, OrderService_UpdateStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *orderServiceClient) Delete(ctx context.Context, in *DeleteOrderRequest, opts ...grpc.CallOption) (*DeleteOrderResponse, error) {
 	out := new(DeleteOrderResponse)
 	err := c.cc.Invoke(ctx, OrderService_Delete_FullMethodName, in, out, opts...)
@@ -101,10 +141,19 @@ func (c *orderServiceClient) List(ctx context.Context, in *ListOrderRequest, opt
 type OrderServiceServer interface {
 	// Creates a new order
 	Create(context.Context, *CreateOrderRequest) (*CreateOrderResponse, error)
+	// Starts creating a new order and returns right away. The returned operation
+	// can be polled through the google.longrunning.Operations service
+	CreateAsync(context.Context, *CreateOrderRequest) (*longrunningpb.Operation, error)
+	// Prices and validates an order without creating it. Payment is not
+	// authorized, stock is not reserved and nothing is saved
+	QuoteOrder(context.Context, *QuoteOrderRequest) (*QuoteOrderResponse, error)
 	// Retrieves an existing order
 	Retrieve(context.Context, *RetrieveOrderRequest) (*RetrieveOrderResponse, error)
-	// Updates an existing order
+	// Updates an existing order. PATCH without an update mask updates the
+	// order fields present in the body
 	Update(context.Context, *UpdateOrderRequest) (*UpdateOrderResponse, error)
+	// Changes the fulfillment status of an existing order
+	UpdateStatus(context.Context, *UpdateOrderStatusRequest) (*UpdateOrderStatusResponse, error)
 	// Cancels an existing order
 	Delete(context.Context, *DeleteOrderRequest) (*DeleteOrderResponse, error)
 	// Lists current orders
@@ -118,12 +167,21 @@ type UnimplementedOrderServiceServer struct {
 func (UnimplementedOrderServiceServer) Create(context.Context, *CreateOrderRequest) (*CreateOrderResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Create not implemented")
 }
+func (UnimplementedOrderServiceServer) CreateAsync(context.Context, *CreateOrderRequest) (*longrunningpb.Operation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAsync not implemented")
+}
+func (UnimplementedOrderServiceServer) QuoteOrder(context.Context, *QuoteOrderRequest) (*QuoteOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QuoteOrder not implemented")
+}
 func (UnimplementedOrderServiceServer) Retrieve(context.Context, *RetrieveOrderRequest) (*RetrieveOrderResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Retrieve not implemented")
 }
 func (UnimplementedOrderServiceServer) Update(context.Context, *UpdateOrderRequest) (*UpdateOrderResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
 }
+func (UnimplementedOrderServiceServer) UpdateStatus(context.Context, *UpdateOrderStatusRequest) (*UpdateOrderStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateStatus not implemented")
+}
 func (UnimplementedOrderServiceServer) Delete(context.Context, *DeleteOrderRequest) (*DeleteOrderResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
@@ -160,6 +218,42 @@ func _OrderService_Create_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _OrderService_CreateAsync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).CreateAsync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderService_CreateAsync_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).CreateAsync(ctx, req.(*CreateOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_QuoteOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuoteOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).QuoteOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderService_QuoteOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).QuoteOrder(ctx, req.(*QuoteOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _OrderService_Retrieve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(RetrieveOrderRequest)
 	if err := dec(in); err != nil {
@@ -196,6 +290,24 @@ func _OrderService_Update_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _OrderService_UpdateStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateOrderStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).UpdateStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderService_UpdateStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).UpdateStatus(ctx, req.(*UpdateOrderStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _OrderService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DeleteOrderRequest)
 	if err := dec(in); err != nil {
@@ -243,6 +355,14 @@ var OrderService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Create",
 			Handler:    _OrderService_Create_Handler,
 		},
+		{
+			MethodName: "CreateAsync",
+			Handler:    _OrderService_CreateAsync_Handler,
+		},
+		{
+			MethodName: "QuoteOrder",
+			Handler:    _OrderService_QuoteOrder_Handler,
+		},
 		{
 			MethodName: "Retrieve",
 			Handler:    _OrderService_Retrieve_Handler,
@@ -251,6 +371,10 @@ var OrderService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Update",
 			Handler:    _OrderService_Update_Handler,
 		},
+		{
+			MethodName: "UpdateStatus",
+			Handler:    _OrderService_UpdateStatus_Handler,
+		},
 		{
 			MethodName: "Delete",
 			Handler:    _OrderService_Delete_Handler,
@@ -0,0 +1,340 @@
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: order.proto
+
+package protoconnect
+
+import (
+	longrunningpb "cloud.google.com/go/longrunning/autogen/longrunningpb"
+	connect "connectrpc.com/connect"
+	context "context"
+	errors "errors"
+	proto "github.com/AndreiMartynenko/grpc-eshop/proto"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect.IsAtLeastVersion1_13_0
+
+const (
+	// OrderServiceName is the fully-qualified name of the OrderService service.
+	OrderServiceName = "orders.OrderService"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// OrderServiceCreateProcedure is the fully-qualified name of the OrderService's Create RPC.
+	OrderServiceCreateProcedure = "/orders.OrderService/Create"
+	// OrderServiceCreateAsyncProcedure is the fully-qualified name of the OrderService's CreateAsync
+	// RPC.
+	OrderServiceCreateAsyncProcedure = "/orders.OrderService/CreateAsync"
+	// OrderServiceQuoteOrderProcedure is the fully-qualified name of the OrderService's QuoteOrder RPC.
+	OrderServiceQuoteOrderProcedure = "/orders.OrderService/QuoteOrder"
+	// OrderServiceRetrieveProcedure is the fully-qualified name of the OrderService's Retrieve RPC.
+	OrderServiceRetrieveProcedure = "/orders.OrderService/Retrieve"
+	// OrderServiceUpdateProcedure is the fully-qualified name of the OrderService's Update RPC.
+	OrderServiceUpdateProcedure = "/orders.OrderService/Update"
+	// OrderServiceUpdateStatusProcedure is the fully-qualified name of the OrderService's UpdateStatus
+	// RPC.
+	OrderServiceUpdateStatusProcedure = "/orders.OrderService/UpdateStatus"
+	// OrderServiceDeleteProcedure is the fully-qualified name of the OrderService's Delete RPC.
+	OrderServiceDeleteProcedure = "/orders.OrderService/Delete"
+	// OrderServiceListProcedure is the fully-qualified name of the OrderService's List RPC.
+	OrderServiceListProcedure = "/orders.OrderService/List"
+)
+
+// These variables are the protoreflect.Descriptor objects for the RPCs defined in this package.
+var (
+	orderServiceServiceDescriptor            = proto.File_order_proto.Services().ByName("OrderService")
+	orderServiceCreateMethodDescriptor       = orderServiceServiceDescriptor.Methods().ByName("Create")
+	orderServiceCreateAsyncMethodDescriptor  = orderServiceServiceDescriptor.Methods().ByName("CreateAsync")
+	orderServiceQuoteOrderMethodDescriptor   = orderServiceServiceDescriptor.Methods().ByName("QuoteOrder")
+	orderServiceRetrieveMethodDescriptor     = orderServiceServiceDescriptor.Methods().ByName("Retrieve")
+	orderServiceUpdateMethodDescriptor       = orderServiceServiceDescriptor.Methods().ByName("Update")
+	orderServiceUpdateStatusMethodDescriptor = orderServiceServiceDescriptor.Methods().ByName("UpdateStatus")
+	orderServiceDeleteMethodDescriptor       = orderServiceServiceDescriptor.Methods().ByName("Delete")
+	orderServiceListMethodDescriptor         = orderServiceServiceDescriptor.Methods().ByName("List")
+)
+
+// OrderServiceClient is a client for the orders.OrderService service.
+type OrderServiceClient interface {
+	// Creates a new order
+	Create(context.Context, *connect.Request[proto.CreateOrderRequest]) (*connect.Response[proto.CreateOrderResponse], error)
+	// Starts creating a new order and returns right away. The returned operation
+	// can be polled through the google.longrunning.Operations service
+	CreateAsync(context.Context, *connect.Request[proto.CreateOrderRequest]) (*connect.Response[longrunningpb.Operation], error)
+	// Prices and validates an order without creating it. Payment is not
+	// authorized, stock is not reserved and nothing is saved
+	QuoteOrder(context.Context, *connect.Request[proto.QuoteOrderRequest]) (*connect.Response[proto.QuoteOrderResponse], error)
+	// Retrieves an existing order
+	Retrieve(context.Context, *connect.Request[proto.RetrieveOrderRequest]) (*connect.Response[proto.RetrieveOrderResponse], error)
+	// Updates an existing order. PATCH without an update mask updates the
+	// order fields present in the body
+	Update(context.Context, *connect.Request[proto.UpdateOrderRequest]) (*connect.Response[proto.UpdateOrderResponse], error)
+	// Changes the fulfillment status of an existing order
+	UpdateStatus(context.Context, *connect.Request[proto.UpdateOrderStatusRequest]) (*connect.Response[proto.UpdateOrderStatusResponse], error)
+	// Cancels an existing order
+	Delete(context.Context, *connect.Request[proto.DeleteOrderRequest]) (*connect.Response[proto.DeleteOrderResponse], error)
+	// Lists current orders
+	List(context.Context, *connect.Request[proto.ListOrderRequest]) (*connect.Response[proto.ListOrderResponse], error)
+}
+
+// NewOrderServiceClient constructs a client for the orders.OrderService service. By default, it
+// uses the Connect protocol with the binary Protobuf Codec, asks for gzipped responses, and sends
+// uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the connect.WithGRPC() or
+// connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewOrderServiceClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) OrderServiceClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &orderServiceClient{
+		create: connect.NewClient[proto.CreateOrderRequest, proto.CreateOrderResponse](
+			httpClient,
+			baseURL+OrderServiceCreateProcedure,
+			connect.WithSchema(orderServiceCreateMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		createAsync: connect.NewClient[proto.CreateOrderRequest, longrunningpb.Operation](
+			httpClient,
+			baseURL+OrderServiceCreateAsyncProcedure,
+			connect.WithSchema(orderServiceCreateAsyncMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		quoteOrder: connect.NewClient[proto.QuoteOrderRequest, proto.QuoteOrderResponse](
+			httpClient,
+			baseURL+OrderServiceQuoteOrderProcedure,
+			connect.WithSchema(orderServiceQuoteOrderMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		retrieve: connect.NewClient[proto.RetrieveOrderRequest, proto.RetrieveOrderResponse](
+			httpClient,
+			baseURL+OrderServiceRetrieveProcedure,
+			connect.WithSchema(orderServiceRetrieveMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		update: connect.NewClient[proto.UpdateOrderRequest, proto.UpdateOrderResponse](
+			httpClient,
+			baseURL+OrderServiceUpdateProcedure,
+			connect.WithSchema(orderServiceUpdateMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		updateStatus: connect.NewClient[proto.UpdateOrderStatusRequest, proto.UpdateOrderStatusResponse](
+			httpClient,
+			baseURL+OrderServiceUpdateStatusProcedure,
+			connect.WithSchema(orderServiceUpdateStatusMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		delete: connect.NewClient[proto.DeleteOrderRequest, proto.DeleteOrderResponse](
+			httpClient,
+			baseURL+OrderServiceDeleteProcedure,
+			connect.WithSchema(orderServiceDeleteMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		list: connect.NewClient[proto.ListOrderRequest, proto.ListOrderResponse](
+			httpClient,
+			baseURL+OrderServiceListProcedure,
+			connect.WithSchema(orderServiceListMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+	}
+}
+
+// orderServiceClient implements OrderServiceClient.
+type orderServiceClient struct {
+	create       *connect.Client[proto.CreateOrderRequest, proto.CreateOrderResponse]
+	createAsync  *connect.Client[proto.CreateOrderRequest, longrunningpb.Operation]
+	quoteOrder   *connect.Client[proto.QuoteOrderRequest, proto.QuoteOrderResponse]
+	retrieve     *connect.Client[proto.RetrieveOrderRequest, proto.RetrieveOrderResponse]
+	update       *connect.Client[proto.UpdateOrderRequest, proto.UpdateOrderResponse]
+	updateStatus *connect.Client[proto.UpdateOrderStatusRequest, proto.UpdateOrderStatusResponse]
+	delete       *connect.Client[proto.DeleteOrderRequest, proto.DeleteOrderResponse]
+	list         *connect.Client[proto.ListOrderRequest, proto.ListOrderResponse]
+}
+
+// Create calls orders.OrderService.Create.
+func (c *orderServiceClient) Create(ctx context.Context, req *connect.Request[proto.CreateOrderRequest]) (*connect.Response[proto.CreateOrderResponse], error) {
+	return c.create.CallUnary(ctx, req)
+}
+
+// CreateAsync calls orders.OrderService.CreateAsync.
+func (c *orderServiceClient) CreateAsync(ctx context.Context, req *connect.Request[proto.CreateOrderRequest]) (*connect.Response[longrunningpb.Operation], error) {
+	return c.createAsync.CallUnary(ctx, req)
+}
+
+// QuoteOrder calls orders.OrderService.QuoteOrder.
+func (c *orderServiceClient) QuoteOrder(ctx context.Context, req *connect.Request[proto.QuoteOrderRequest]) (*connect.Response[proto.QuoteOrderResponse], error) {
+	return c.quoteOrder.CallUnary(ctx, req)
+}
+
+// Retrieve calls orders.OrderService.Retrieve.
+func (c *orderServiceClient) Retrieve(ctx context.Context, req *connect.Request[proto.RetrieveOrderRequest]) (*connect.Response[proto.RetrieveOrderResponse], error) {
+	return c.retrieve.CallUnary(ctx, req)
+}
+
+// Update calls orders.OrderService.Update.
+func (c *orderServiceClient) Update(ctx context.Context, req *connect.Request[proto.UpdateOrderRequest]) (*connect.Response[proto.UpdateOrderResponse], error) {
+	return c.update.CallUnary(ctx, req)
+}
+
+// UpdateStatus calls orders.OrderService.UpdateStatus.
+func (c *orderServiceClient) UpdateStatus(ctx context.Context, req *connect.Request[proto.UpdateOrderStatusRequest]) (*connect.Response[proto.UpdateOrderStatusResponse], error) {
+	return c.updateStatus.CallUnary(ctx, req)
+}
+
+// Delete calls orders.OrderService.Delete.
+func (c *orderServiceClient) Delete(ctx context.Context, req *connect.Request[proto.DeleteOrderRequest]) (*connect.Response[proto.DeleteOrderResponse], error) {
+	return c.delete.CallUnary(ctx, req)
+}
+
+// List calls orders.OrderService.List.
+func (c *orderServiceClient) List(ctx context.Context, req *connect.Request[proto.ListOrderRequest]) (*connect.Response[proto.ListOrderResponse], error) {
+	return c.list.CallUnary(ctx, req)
+}
+
+// OrderServiceHandler is an implementation of the orders.OrderService service.
+type OrderServiceHandler interface {
+	// Creates a new order
+	Create(context.Context, *connect.Request[proto.CreateOrderRequest]) (*connect.Response[proto.CreateOrderResponse], error)
+	// Starts creating a new order and returns right away. The returned operation
+	// can be polled through the google.longrunning.Operations service
+	CreateAsync(context.Context, *connect.Request[proto.CreateOrderRequest]) (*connect.Response[longrunningpb.Operation], error)
+	// Prices and validates an order without creating it. Payment is not
+	// authorized, stock is not reserved and nothing is saved
+	QuoteOrder(context.Context, *connect.Request[proto.QuoteOrderRequest]) (*connect.Response[proto.QuoteOrderResponse], error)
+	// Retrieves an existing order
+	Retrieve(context.Context, *connect.Request[proto.RetrieveOrderRequest]) (*connect.Response[proto.RetrieveOrderResponse], error)
+	// Updates an existing order. PATCH without an update mask updates the
+	// order fields present in the body
+	Update(context.Context, *connect.Request[proto.UpdateOrderRequest]) (*connect.Response[proto.UpdateOrderResponse], error)
+	// Changes the fulfillment status of an existing order
+	UpdateStatus(context.Context, *connect.Request[proto.UpdateOrderStatusRequest]) (*connect.Response[proto.UpdateOrderStatusResponse], error)
+	// Cancels an existing order
+	Delete(context.Context, *connect.Request[proto.DeleteOrderRequest]) (*connect.Response[proto.DeleteOrderResponse], error)
+	// Lists current orders
+	List(context.Context, *connect.Request[proto.ListOrderRequest]) (*connect.Response[proto.ListOrderResponse], error)
+}
+
+// NewOrderServiceHandler builds an HTTP handler from the service implementation. It returns the
+// path on which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewOrderServiceHandler(svc OrderServiceHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	orderServiceCreateHandler := connect.NewUnaryHandler(
+		OrderServiceCreateProcedure,
+		svc.Create,
+		connect.WithSchema(orderServiceCreateMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	orderServiceCreateAsyncHandler := connect.NewUnaryHandler(
+		OrderServiceCreateAsyncProcedure,
+		svc.CreateAsync,
+		connect.WithSchema(orderServiceCreateAsyncMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	orderServiceQuoteOrderHandler := connect.NewUnaryHandler(
+		OrderServiceQuoteOrderProcedure,
+		svc.QuoteOrder,
+		connect.WithSchema(orderServiceQuoteOrderMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	orderServiceRetrieveHandler := connect.NewUnaryHandler(
+		OrderServiceRetrieveProcedure,
+		svc.Retrieve,
+		connect.WithSchema(orderServiceRetrieveMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	orderServiceUpdateHandler := connect.NewUnaryHandler(
+		OrderServiceUpdateProcedure,
+		svc.Update,
+		connect.WithSchema(orderServiceUpdateMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	orderServiceUpdateStatusHandler := connect.NewUnaryHandler(
+		OrderServiceUpdateStatusProcedure,
+		svc.UpdateStatus,
+		connect.WithSchema(orderServiceUpdateStatusMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	orderServiceDeleteHandler := connect.NewUnaryHandler(
+		OrderServiceDeleteProcedure,
+		svc.Delete,
+		connect.WithSchema(orderServiceDeleteMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	orderServiceListHandler := connect.NewUnaryHandler(
+		OrderServiceListProcedure,
+		svc.List,
+		connect.WithSchema(orderServiceListMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	return "/orders.OrderService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case OrderServiceCreateProcedure:
+			orderServiceCreateHandler.ServeHTTP(w, r)
+		case OrderServiceCreateAsyncProcedure:
+			orderServiceCreateAsyncHandler.ServeHTTP(w, r)
+		case OrderServiceQuoteOrderProcedure:
+			orderServiceQuoteOrderHandler.ServeHTTP(w, r)
+		case OrderServiceRetrieveProcedure:
+			orderServiceRetrieveHandler.ServeHTTP(w, r)
+		case OrderServiceUpdateProcedure:
+			orderServiceUpdateHandler.ServeHTTP(w, r)
+		case OrderServiceUpdateStatusProcedure:
+			orderServiceUpdateStatusHandler.ServeHTTP(w, r)
+		case OrderServiceDeleteProcedure:
+			orderServiceDeleteHandler.ServeHTTP(w, r)
+		case OrderServiceListProcedure:
+			orderServiceListHandler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// UnimplementedOrderServiceHandler returns CodeUnimplemented from all methods.
+type UnimplementedOrderServiceHandler struct{}
+
+func (UnimplementedOrderServiceHandler) Create(context.Context, *connect.Request[proto.CreateOrderRequest]) (*connect.Response[proto.CreateOrderResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.Create is not implemented"))
+}
+
+func (UnimplementedOrderServiceHandler) CreateAsync(context.Context, *connect.Request[proto.CreateOrderRequest]) (*connect.Response[longrunningpb.Operation], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.CreateAsync is not implemented"))
+}
+
+func (UnimplementedOrderServiceHandler) QuoteOrder(context.Context, *connect.Request[proto.QuoteOrderRequest]) (*connect.Response[proto.QuoteOrderResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.QuoteOrder is not implemented"))
+}
+
+func (UnimplementedOrderServiceHandler) Retrieve(context.Context, *connect.Request[proto.RetrieveOrderRequest]) (*connect.Response[proto.RetrieveOrderResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.Retrieve is not implemented"))
+}
+
+func (UnimplementedOrderServiceHandler) Update(context.Context, *connect.Request[proto.UpdateOrderRequest]) (*connect.Response[proto.UpdateOrderResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.Update is not implemented"))
+}
+
+func (UnimplementedOrderServiceHandler) UpdateStatus(context.Context, *connect.Request[proto.UpdateOrderStatusRequest]) (*connect.Response[proto.UpdateOrderStatusResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.UpdateStatus is not implemented"))
+}
+
+func (UnimplementedOrderServiceHandler) Delete(context.Context, *connect.Request[proto.DeleteOrderRequest]) (*connect.Response[proto.DeleteOrderResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.Delete is not implemented"))
+}
+
+func (UnimplementedOrderServiceHandler) List(context.Context, *connect.Request[proto.ListOrderRequest]) (*connect.Response[proto.ListOrderResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("orders.OrderService.List is not implemented"))
+}